@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,23 @@ import (
 	"time"
 )
 
+// staleRetention is how long an expired entry stays on disk so it can still
+// serve as a fallback (e.g. when a command times out)
+const staleRetention = 24 * time.Hour
+
+// Cache scopes decide which renders share a cached value
+const (
+	cacheScopeGlobal     = "global"      // One value for the whole machine
+	cacheScopeCwd        = "cwd"         // One value per working directory (default)
+	cacheScopeProjectDir = "project_dir" // One value per workspace.project_dir
+	cacheScopeSessionID  = "session_id"  // One value per Claude Code session
+	cacheScopeGitHead    = "git_head"    // One value per directory and checked out commit
+	cacheScopeCustom     = "cache_key"   // Value of the expanded cache_key template
+)
+
+// lockPollInterval is how often Lock retries a lock held by another process
+const lockPollInterval = 20 * time.Millisecond
+
 type Cache struct {
 	dir string
 }
@@ -17,6 +35,20 @@ type Cache struct {
 type cacheEntry struct {
 	Result    string `json:"result"`
 	ExpiresAt int64  `json:"expires_at"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	Action    string `json:"action,omitempty"`  // Action that produced the result
+	Project   string `json:"project,omitempty"` // Directory the result was computed for ("" for global entries)
+	Retain    bool   `json:"retain,omitempty"`  // Kept past staleRetention as the fallback of on_error: cached
+}
+
+// newCacheEntry creates an entry for result that expires after ttl seconds
+func newCacheEntry(result string, ttl int) cacheEntry {
+	now := time.Now().Unix()
+	return cacheEntry{
+		Result:    result,
+		ExpiresAt: now + int64(ttl),
+		CreatedAt: now,
+	}
 }
 
 func NewCache(dir string) *Cache {
@@ -40,44 +72,314 @@ func NewDefaultCache() *Cache {
 }
 
 func (c *Cache) Get(name string) (string, bool) {
-	filePath := filepath.Join(c.dir, name+".json")
+	entry, ok := c.readEntry(name)
+	if !ok {
+		return "", false
+	}
 
-	data, err := os.ReadFile(filePath)
-	if err != nil {
+	if time.Now().Unix() > entry.ExpiresAt {
 		return "", false
 	}
 
-	var entry cacheEntry
-	if err := json.Unmarshal(data, &entry); err != nil {
+	return entry.Result, true
+}
+
+// GetStale retrieves a cached value even if it has already expired
+func (c *Cache) GetStale(name string) (string, bool) {
+	entry, ok := c.readEntry(name)
+	if !ok {
 		return "", false
 	}
+	return entry.Result, true
+}
 
-	if time.Now().Unix() > entry.ExpiresAt {
+// GetStaleWithin retrieves a cached value that expired at most staleTTL seconds ago
+func (c *Cache) GetStaleWithin(name string, staleTTL int) (string, bool) {
+	entry, ok := c.readEntry(name)
+	if !ok {
+		return "", false
+	}
+
+	if time.Now().Unix() > entry.ExpiresAt+int64(staleTTL) {
 		return "", false
 	}
 
 	return entry.Result, true
 }
 
+func (c *Cache) readEntry(name string) (cacheEntry, bool) {
+	filePath := filepath.Join(c.dir, name+".json")
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return cacheEntry{}, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return cacheEntry{}, false
+	}
+
+	return entry, true
+}
+
 func (c *Cache) Set(name string, result string, ttl int) error {
+	return c.SetEntry(name, newCacheEntry(result, ttl))
+}
+
+// SetEntry stores a complete entry, including its metadata
+func (c *Cache) SetEntry(name string, entry cacheEntry) error {
 	if err := os.MkdirAll(c.dir, 0755); err != nil {
 		return err
 	}
 
-	entry := cacheEntry{
-		Result:    result,
-		ExpiresAt: time.Now().Unix() + int64(ttl),
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
 	}
 
-	data, err := json.Marshal(entry)
+	return writeFileAtomic(filepath.Join(c.dir, name+".json"), data)
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place, so concurrent readers see either the old or the new content in full
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	tmpPath := tmp.Name()
 
-	filePath := filepath.Join(c.dir, name+".json")
-	return os.WriteFile(filePath, data, 0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Lock takes an exclusive advisory lock on name, waiting until it is free
+// or ctx is done. Other ccstatusline processes computing the same entry
+// block here, then find the fresh result in the cache instead of re-running
+// the command. The returned function releases the lock.
+func (c *Cache) Lock(ctx context.Context, name string) (func(), error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(c.dir, name+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// TryLockRefresh marks name as being refreshed. It returns false if another
+// process already holds the mark, unless that mark is older than maxAge
+// (left behind by a refresh that crashed).
+func (c *Cache) TryLockRefresh(name string, maxAge time.Duration) bool {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return false
+	}
+
+	lockPath := filepath.Join(c.dir, name+".refresh")
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return true
+		}
+		if !os.IsExist(err) {
+			return false
+		}
+
+		info, err := os.Stat(lockPath)
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			return false
+		}
+		// Break the abandoned lock and try once more
+		os.Remove(lockPath)
+	}
+	return false
+}
+
+// UnlockRefresh removes the refresh mark set by TryLockRefresh
+func (c *Cache) UnlockRefresh(name string) {
+	os.Remove(filepath.Join(c.dir, name+".refresh"))
+}
+
+// cacheListing is a cache entry together with its key
+type cacheListing struct {
+	Key string
+	cacheEntry
+}
+
+// List returns all readable entries sorted by key
+func (c *Cache) List() ([]cacheListing, error) {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var listings []cacheListing
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		key := strings.TrimSuffix(name, ".json")
+		entry, ok := c.readEntry(key)
+		if !ok {
+			continue
+		}
+		listings = append(listings, cacheListing{Key: key, cacheEntry: entry})
+	}
+
+	// ReadDir already sorts by file name
+	return listings, nil
+}
+
+// Lookup returns the entry stored under name, expired or not
+func (c *Cache) Lookup(name string) (cacheEntry, bool) {
+	return c.readEntry(name)
+}
+
+// Remove deletes the entry stored under name along with any refresh mark
+func (c *Cache) Remove(name string) error {
+	os.Remove(filepath.Join(c.dir, name+".refresh"))
+	err := os.Remove(filepath.Join(c.dir, name+".json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Prune removes entries past their retention as well as files left behind by
+// interrupted writes, crashed refreshes and deleted entries. It returns the
+// number of files removed.
+func (c *Cache) Prune() (int, error) {
+	before, err := c.countFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.CleanExpired(); err != nil {
+		return 0, err
+	}
+
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		path := filepath.Join(c.dir, name)
+		info, err := file.Info()
+		if err != nil || file.IsDir() {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-"):
+			// Temp file of a write that never got renamed into place
+			if time.Since(info.ModTime()) > time.Minute {
+				os.Remove(path)
+			}
+		case strings.HasSuffix(name, ".refresh"):
+			if time.Since(info.ModTime()) > refreshLockTimeout {
+				os.Remove(path)
+			}
+		case strings.HasSuffix(name, ".lock"):
+			// Only drop lock files whose entry is gone and nobody holds.
+			// A process that opened the file just before removal may still
+			// lock the unlinked file; at worst it re-runs its command.
+			key := strings.TrimSuffix(name, ".lock")
+			if _, err := os.Stat(filepath.Join(c.dir, key+".json")); !os.IsNotExist(err) {
+				continue
+			}
+			f, err := os.OpenFile(path, os.O_RDWR, 0644)
+			if err != nil {
+				continue
+			}
+			if locked, _ := tryLockFile(f); locked {
+				os.Remove(path)
+				unlockFile(f)
+			}
+			f.Close()
+		}
+	}
+
+	after, err := c.countFiles()
+	if err != nil {
+		return 0, err
+	}
+	return before - after + c.pruneTranscripts(), nil
 }
 
+// countFiles counts the regular files in the cache directory
+func (c *Cache) countFiles() (int, error) {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	count := 0
+	for _, file := range files {
+		if !file.IsDir() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CleanExpired removes entries that expired more than staleRetention ago.
+// The newest retained entry of each action and project is kept until the
+// project directory is gone, since on_error: cached has nothing else to fall
+// back on. Older ones, left behind by session_id, git_head or cache_key
+// scopes, expire like any other entry.
 func (c *Cache) CleanExpired() error {
 	entries, err := os.ReadDir(c.dir)
 	if err != nil {
@@ -87,8 +389,11 @@ func (c *Cache) CleanExpired() error {
 		return err
 	}
 
-	now := time.Now().Unix()
+	cutoff := time.Now().Add(-staleRetention).Unix()
 
+	type retainKey struct{ action, project string }
+	paths := make(map[string]cacheEntry)
+	newest := make(map[retainKey]string)
 	for _, entry := range entries {
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
 			continue
@@ -104,8 +409,27 @@ func (c *Cache) CleanExpired() error {
 		if err := json.Unmarshal(data, &cacheEntry); err != nil {
 			continue
 		}
+		paths[filePath] = cacheEntry
 
-		if now > cacheEntry.ExpiresAt {
+		if cacheEntry.Retain {
+			key := retainKey{cacheEntry.Action, cacheEntry.Project}
+			if current, ok := newest[key]; !ok || cacheEntry.CreatedAt > paths[current].CreatedAt ||
+				cacheEntry.CreatedAt == paths[current].CreatedAt && filePath > current {
+				newest[key] = filePath
+			}
+		}
+	}
+
+	for filePath, cacheEntry := range paths {
+		if cacheEntry.Retain && newest[retainKey{cacheEntry.Action, cacheEntry.Project}] == filePath {
+			if cacheEntry.Project == "" {
+				continue
+			}
+			if _, err := os.Stat(cacheEntry.Project); !os.IsNotExist(err) {
+				continue
+			}
+		}
+		if cutoff > cacheEntry.ExpiresAt {
 			os.Remove(filePath)
 		}
 	}
@@ -114,16 +438,43 @@ func (c *Cache) CleanExpired() error {
 }
 
 // GenerateCacheKey generates a cache key from cwd and action name
-// Format: {projectName}_{parentHashFirst4Chars}_{actionName}
+// Format: {projectName}_{cwdHash}_{actionName}
 func (c *Cache) GenerateCacheKey(cwd string, actionName string) string {
-	projectName := filepath.Base(cwd)
-	parentPath := filepath.Dir(cwd)
-	
-	// Generate hash of parent path
-	hash := sha256.Sum256([]byte(parentPath))
-	hashStr := fmt.Sprintf("%x", hash[:2]) // First 2 bytes = 4 hex chars
-	
-	return fmt.Sprintf("%s_%s_%s", projectName, hashStr, actionName)
+	return c.GenerateScopedKey(filepath.Base(cwd), cacheScopeCwd, cwd, actionName)
+}
+
+// GenerateScopedKey generates a cache key for an action whose cached value
+// is shared by everything with the same scope value.
+// Format: {label}_{hash}_{actionName}
+// The label only makes file names readable. The hash covers the scope and the
+// full scope value, so different scopes or values never share a key.
+func (c *Cache) GenerateScopedKey(label string, scope string, value string, actionName string) string {
+	hash := sha256.Sum256([]byte(scope + "\x00" + value + "\x00" + actionName))
+	return fmt.Sprintf("%s_%x_%s", sanitizeKeyPart(label), hash, sanitizeKeyPart(actionName))
+}
+
+// sanitizeKeyPart makes s safe to use in a file name
+func sanitizeKeyPart(s string) string {
+	const maxLen = 48
+
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+		if b.Len() >= maxLen {
+			break
+		}
+	}
+
+	result := strings.Trim(b.String(), ".")
+	if result == "" {
+		return "-"
+	}
+	return result
 }
 
 // GetWithCwd retrieves a cached value using cwd and action name
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+const cacheUsage = `Usage: ccstatusline cache <command> [options]
+
+Commands:
+  list                 List cached entries with project, action, age and remaining TTL
+  show <key>           Show a single entry including its cached result
+  clear [filters]      Remove entries (all entries when no filter is given)
+      --action NAME    Only entries produced by this action
+      --project DIR    Only entries for this project (full path or directory name)
+      --expired        Only entries whose TTL has passed
+  prune                Remove long-expired entries and leftover temp and lock files
+`
+
+// runCacheCommand implements `ccstatusline cache <command>`
+func runCacheCommand(args []string, cache *Cache, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing cache command\n\n%s", cacheUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return cacheList(cache, stdout)
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: ccstatusline cache show <key>")
+		}
+		return cacheShow(cache, args[1], stdout)
+	case "clear":
+		return cacheClear(cache, args[1:], stdout)
+	case "prune":
+		removed, err := cache.Prune()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Removed %d files\n", removed)
+		return nil
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, cacheUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache command %q\n\n%s", args[0], cacheUsage)
+	}
+}
+
+// cacheList prints one row per entry
+func cacheList(cache *Cache, stdout io.Writer) error {
+	listings, err := cache.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tPROJECT\tACTION\tAGE\tTTL")
+	for _, listing := range listings {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			listing.Key,
+			orDash(listing.Project),
+			orDash(listing.Action),
+			formatAge(listing.cacheEntry, now),
+			formatRemaining(listing.cacheEntry, now),
+		)
+	}
+	return w.Flush()
+}
+
+// cacheShow prints all details of one entry
+func cacheShow(cache *Cache, key string, stdout io.Writer) error {
+	entry, ok := cache.Lookup(key)
+	if !ok {
+		return fmt.Errorf("no cache entry %q", key)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Key:\t%s\n", key)
+	fmt.Fprintf(w, "Project:\t%s\n", orDash(entry.Project))
+	fmt.Fprintf(w, "Action:\t%s\n", orDash(entry.Action))
+	if entry.CreatedAt > 0 {
+		fmt.Fprintf(w, "Created:\t%s (%s ago)\n", time.Unix(entry.CreatedAt, 0).Format(time.RFC3339), formatAge(entry, now))
+	}
+	fmt.Fprintf(w, "Expires:\t%s (%s)\n", time.Unix(entry.ExpiresAt, 0).Format(time.RFC3339), formatRemaining(entry, now))
+	fmt.Fprintf(w, "Result:\t%s\n", entry.Result)
+	return w.Flush()
+}
+
+// cacheClear removes entries matching all given filters
+func cacheClear(cache *Cache, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("cache clear", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	action := flags.String("action", "", "Only entries produced by this action")
+	project := flags.String("project", "", "Only entries for this project")
+	expired := flags.Bool("expired", false, "Only expired entries")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w\n\n%s", err, cacheUsage)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q\n\n%s", flags.Arg(0), cacheUsage)
+	}
+
+	listings, err := cache.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	removed := 0
+	for _, listing := range listings {
+		if *action != "" && listing.Action != *action {
+			continue
+		}
+		if *project != "" && !matchesProject(listing.Project, *project) {
+			continue
+		}
+		if *expired && now <= listing.ExpiresAt {
+			continue
+		}
+
+		if err := cache.Remove(listing.Key); err != nil {
+			return err
+		}
+		removed++
+	}
+
+	fmt.Fprintf(stdout, "Removed %d entries\n", removed)
+	return nil
+}
+
+// matchesProject reports whether an entry's project matches a --project filter,
+// given either as a full path or as the project's directory name
+func matchesProject(entryProject string, filter string) bool {
+	if entryProject == "" {
+		return false
+	}
+	if filepath.IsAbs(filter) {
+		return filepath.Clean(filter) == filepath.Clean(entryProject)
+	}
+	return filepath.Base(entryProject) == filter
+}
+
+// formatAge returns how long ago the entry was created
+func formatAge(entry cacheEntry, now time.Time) string {
+	if entry.CreatedAt == 0 {
+		return "-"
+	}
+	return formatDuration(now.Sub(time.Unix(entry.CreatedAt, 0)))
+}
+
+// formatRemaining returns the remaining TTL of the entry
+func formatRemaining(entry cacheEntry, now time.Time) string {
+	remaining := time.Unix(entry.ExpiresAt, 0).Sub(now)
+	if remaining < 0 {
+		return "expired " + formatDuration(-remaining) + " ago"
+	}
+	return formatDuration(remaining)
+}
+
+// formatDuration formats d with its largest unit, e.g. 45s, 3m, 2h or 5d
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
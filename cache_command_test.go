@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedCache creates a cache with entries for two projects, one of them expired
+func seedCache(t *testing.T) *Cache {
+	t.Helper()
+	cache := NewCache(t.TempDir())
+
+	entries := map[string]cacheEntry{
+		"app_1_branch": {Result: "main", Action: "branch", Project: "/work/app", ExpiresAt: time.Now().Add(time.Minute).Unix(), CreatedAt: time.Now().Unix()},
+		"app_1_pr":     {Result: "#12", Action: "pr", Project: "/work/app", ExpiresAt: time.Now().Add(-time.Minute).Unix(), CreatedAt: time.Now().Add(-time.Hour).Unix()},
+		"lib_2_branch": {Result: "dev", Action: "branch", Project: "/work/lib", ExpiresAt: time.Now().Add(time.Minute).Unix(), CreatedAt: time.Now().Unix()},
+	}
+	for key, entry := range entries {
+		if err := cache.SetEntry(key, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cache
+}
+
+func TestCacheCommandList(t *testing.T) {
+	cache := seedCache(t)
+
+	var out bytes.Buffer
+	if err := runCacheCommand([]string{"list"}, cache, &out); err != nil {
+		t.Fatalf("cache list error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected header and 3 rows, got:\n%s", out.String())
+	}
+	for _, want := range []string{"KEY", "PROJECT", "ACTION", "AGE", "TTL"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("Header %q does not contain %q", lines[0], want)
+		}
+	}
+	if !strings.Contains(lines[2], "/work/app") || !strings.Contains(lines[2], "pr") || !strings.Contains(lines[2], "1h") || !strings.Contains(lines[2], "expired") {
+		t.Errorf("Unexpected row for expired entry: %q", lines[2])
+	}
+}
+
+func TestCacheCommandShow(t *testing.T) {
+	cache := seedCache(t)
+
+	var out bytes.Buffer
+	if err := runCacheCommand([]string{"show", "app_1_pr"}, cache, &out); err != nil {
+		t.Fatalf("cache show error = %v", err)
+	}
+	for _, want := range []string{"app_1_pr", "/work/app", "#12", "expired"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("cache show output does not contain %q:\n%s", want, out.String())
+		}
+	}
+
+	if err := runCacheCommand([]string{"show", "missing"}, cache, &out); err == nil {
+		t.Error("Expected error for missing key")
+	}
+}
+
+func TestCacheCommandClear(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		remaining []string
+	}{
+		{name: "all", args: nil, remaining: nil},
+		{name: "by action", args: []string{"--action", "branch"}, remaining: []string{"app_1_pr"}},
+		{name: "by project name", args: []string{"--project", "lib"}, remaining: []string{"app_1_branch", "app_1_pr"}},
+		{name: "by project path", args: []string{"--project", "/work/app"}, remaining: []string{"lib_2_branch"}},
+		{name: "expired only", args: []string{"--expired"}, remaining: []string{"app_1_branch", "lib_2_branch"}},
+		{name: "combined filters", args: []string{"--project", "app", "--action", "branch"}, remaining: []string{"app_1_pr", "lib_2_branch"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := seedCache(t)
+
+			var out bytes.Buffer
+			if err := runCacheCommand(append([]string{"clear"}, tt.args...), cache, &out); err != nil {
+				t.Fatalf("cache clear error = %v", err)
+			}
+
+			listings, err := cache.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, listing := range listings {
+				keys = append(keys, listing.Key)
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.remaining, ",") {
+				t.Errorf("Remaining keys = %v, want %v", keys, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestCacheCommandPrune(t *testing.T) {
+	cache := NewCache(t.TempDir())
+	old := time.Now().Add(-2 * time.Hour)
+
+	writeFiles(t, cache.dir, map[string]string{
+		"ancient.json":          `{"result":"x","expires_at":1}`,
+		"ancient.lock":          "",
+		"fresh.json":            `{"result":"y","expires_at":9999999999}`,
+		"fresh.lock":            "",
+		".fresh.json.tmp-12345": "partial",
+		"fresh.refresh":         "",
+	})
+	for _, name := range []string{".fresh.json.tmp-12345", "fresh.refresh"} {
+		if err := os.Chtimes(filepath.Join(cache.dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := runCacheCommand([]string{"prune"}, cache, &out); err != nil {
+		t.Fatalf("cache prune error = %v", err)
+	}
+	if !strings.Contains(out.String(), "Removed 4 files") {
+		t.Errorf("Unexpected prune output: %q", out.String())
+	}
+
+	files, _ := os.ReadDir(cache.dir)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if strings.Join(names, ",") != "fresh.json,fresh.lock" {
+		t.Errorf("Remaining files = %v, want [fresh.json fresh.lock]", names)
+	}
+}
+
+func TestCacheCommandUnknown(t *testing.T) {
+	var out bytes.Buffer
+	if err := runCacheCommand(nil, NewCache(t.TempDir()), &out); err == nil {
+		t.Error("Expected error without command")
+	}
+	if err := runCacheCommand([]string{"purge"}, NewCache(t.TempDir()), &out); err == nil {
+		t.Error("Expected error for unknown command")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -129,6 +130,79 @@ func TestCache_CleanExpired(t *testing.T) {
 	}
 }
 
+func TestCache_CleanExpiredKeepsRetainedEntries(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewCache(tempDir)
+	project := t.TempDir()
+	longAgo := time.Now().Add(-2 * staleRetention)
+
+	entries := map[string]cacheEntry{
+		"retained":        {Result: "main", ExpiresAt: longAgo.Unix(), Project: project, Retain: true},
+		"retained-global": {Result: "main", ExpiresAt: longAgo.Unix(), Retain: true},
+		"project-gone":    {Result: "main", ExpiresAt: longAgo.Unix(), Project: filepath.Join(project, "deleted"), Retain: true},
+		"not-retained":    {Result: "main", ExpiresAt: longAgo.Unix(), Project: project},
+	}
+	for name, entry := range entries {
+		if err := cache.SetEntry(name, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cache.CleanExpired(); err != nil {
+		t.Fatalf("CleanExpired() error = %v", err)
+	}
+
+	for name, kept := range map[string]bool{"retained": true, "retained-global": true, "project-gone": false, "not-retained": false} {
+		if _, ok := cache.GetStale(name); ok != kept {
+			t.Errorf("%s: kept = %v, want %v", name, ok, kept)
+		}
+	}
+}
+
+func TestCache_CleanExpiredKeepsNewestRetainedEntry(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewCache(tempDir)
+	project := t.TempDir()
+	longAgo := time.Now().Add(-2 * staleRetention)
+
+	// One entry per session, as cache_scope: session_id leaves behind
+	for i := 0; i < 5; i++ {
+		entry := cacheEntry{
+			Result:    fmt.Sprintf("session%d", i),
+			ExpiresAt: longAgo.Unix(),
+			CreatedAt: longAgo.Unix() - int64(5-i),
+			Action:    "git",
+			Project:   project,
+			Retain:    true,
+		}
+		if err := cache.SetEntry(fmt.Sprintf("session%d_git", i), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := cacheEntry{Result: "other", ExpiresAt: longAgo.Unix(), CreatedAt: longAgo.Unix() - 10, Action: "pr", Project: project, Retain: true}
+	if err := cache.SetEntry("session0_pr", other); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.CleanExpired(); err != nil {
+		t.Fatalf("CleanExpired() error = %v", err)
+	}
+
+	count, err := cache.countFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("countFiles() = %d, want 2", count)
+	}
+	if result, ok := cache.GetStale("session4_git"); !ok || result != "session4" {
+		t.Errorf("GetStale(session4_git) = %q, %v, want the newest entry", result, ok)
+	}
+	if _, ok := cache.GetStale("session0_pr"); !ok {
+		t.Error("the retained entry of another action was removed")
+	}
+}
+
 func TestCache_GenerateCacheKey(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -140,19 +214,19 @@ func TestCache_GenerateCacheKey(t *testing.T) {
 			name:        "simple project path",
 			cwd:         "/Users/yasuhisa.yoshida/work/ccstatusline",
 			actionName:  "github_pr",
-			wantPattern: `^ccstatusline_[a-f0-9]{4}_github_pr$`,
+			wantPattern: `^ccstatusline_[a-f0-9]{64}_github_pr$`,
 		},
 		{
 			name:        "different parent same project name",
 			cwd:         "/home/user/projects/ccstatusline",
 			actionName:  "github_pr",
-			wantPattern: `^ccstatusline_[a-f0-9]{4}_github_pr$`,
+			wantPattern: `^ccstatusline_[a-f0-9]{64}_github_pr$`,
 		},
 		{
 			name:        "root directory project",
 			cwd:         "/ccstatusline",
 			actionName:  "git_branch",
-			wantPattern: `^ccstatusline_[a-f0-9]{4}_git_branch$`,
+			wantPattern: `^ccstatusline_[a-f0-9]{64}_git_branch$`,
 		},
 	}
 
@@ -232,3 +306,172 @@ func TestCache_GetSet_WithCwd(t *testing.T) {
 		t.Errorf("GetWithCwd() for project2 = %v, want PR-456", got2)
 	}
 }
+
+func TestCache_GetStale(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewCache(tempDir)
+
+	cacheFile := filepath.Join(tempDir, "test_action.json")
+	expiredData := fmt.Sprintf(`{"result":"old data","expires_at":%d}`, time.Now().Add(-time.Minute).Unix())
+	if err := os.WriteFile(cacheFile, []byte(expiredData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.Get("test_action"); ok {
+		t.Error("Expected cache miss for expired entry, got hit")
+	}
+
+	result, ok := cache.GetStale("test_action")
+	if !ok {
+		t.Fatal("Expected stale cache hit, got miss")
+	}
+	if result != "old data" {
+		t.Errorf("Expected 'old data', got: %s", result)
+	}
+
+	// 最近期限切れになったエントリはフォールバック用に残す
+	if err := cache.CleanExpired(); err != nil {
+		t.Fatalf("Failed to clean expired cache: %v", err)
+	}
+	if _, err := os.Stat(cacheFile); os.IsNotExist(err) {
+		t.Error("Recently expired cache file was deleted")
+	}
+}
+
+func TestCache_GetStaleWithin(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewCache(tempDir)
+
+	cacheFile := filepath.Join(tempDir, "test_action.json")
+	expiredData := fmt.Sprintf(`{"result":"old data","expires_at":%d}`, time.Now().Add(-time.Minute).Unix())
+	if err := os.WriteFile(cacheFile, []byte(expiredData), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, ok := cache.GetStaleWithin("test_action", 300); !ok || result != "old data" {
+		t.Errorf("GetStaleWithin(300) = %q, %v, want 'old data', true", result, ok)
+	}
+	if _, ok := cache.GetStaleWithin("test_action", 30); ok {
+		t.Error("GetStaleWithin(30) should miss for entry expired a minute ago")
+	}
+}
+
+func TestCache_TryLockRefresh(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewCache(tempDir)
+
+	if !cache.TryLockRefresh("test_action", time.Minute) {
+		t.Fatal("Expected first lock to succeed")
+	}
+	if cache.TryLockRefresh("test_action", time.Minute) {
+		t.Error("Expected second lock to fail while held")
+	}
+
+	cache.UnlockRefresh("test_action")
+	if !cache.TryLockRefresh("test_action", time.Minute) {
+		t.Error("Expected lock to succeed after unlock")
+	}
+
+	// 古いロックは放棄されたものとみなして奪う
+	lockPath := filepath.Join(tempDir, "test_action.refresh")
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.TryLockRefresh("test_action", time.Minute) {
+		t.Error("Expected abandoned lock to be broken")
+	}
+}
+
+func TestCache_SetIsAtomic(t *testing.T) {
+	tempDir := t.TempDir()
+	cache := NewCache(tempDir)
+	large := strings.Repeat("x", 1<<20)
+
+	if err := cache.Set("test_action", large, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			cache.Set("test_action", large, 60)
+		}
+	}()
+
+	// 書き込み中でも読み込みは常に完全なエントリを返す
+	for {
+		select {
+		case <-done:
+			entries, _ := os.ReadDir(tempDir)
+			for _, entry := range entries {
+				if strings.Contains(entry.Name(), ".tmp-") {
+					t.Errorf("Temp file left behind: %s", entry.Name())
+				}
+			}
+			return
+		default:
+		}
+		if _, ok := cache.Get("test_action"); !ok {
+			t.Fatal("Reader observed a partially written cache entry")
+		}
+	}
+}
+
+func TestCache_Lock(t *testing.T) {
+	cache := NewCache(t.TempDir())
+
+	unlock, err := cache.Lock(context.Background(), "test_action")
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// 別のロック取得はタイムアウトするまで待たされる
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := cache.Lock(ctx, "test_action"); err == nil {
+		t.Fatal("Expected second Lock() to wait until timeout")
+	}
+
+	// 別のキーは独立してロックできる
+	unlockOther, err := cache.Lock(context.Background(), "other_action")
+	if err != nil {
+		t.Fatalf("Lock() on other key error = %v", err)
+	}
+	unlockOther()
+
+	unlock()
+	unlock2, err := cache.Lock(context.Background(), "test_action")
+	if err != nil {
+		t.Fatalf("Lock() after unlock error = %v", err)
+	}
+	unlock2()
+}
+
+func TestCache_GenerateScopedKey(t *testing.T) {
+	cache := NewCache(t.TempDir())
+
+	keys := map[string]string{
+		"global":             cache.GenerateScopedKey("global", cacheScopeGlobal, "", "docker"),
+		"cwd":                cache.GenerateScopedKey("global", cacheScopeCwd, "", "docker"),
+		"other value":        cache.GenerateScopedKey("global", cacheScopeGlobal, "x", "docker"),
+		"sanitized collides": cache.GenerateScopedKey("a/b", cacheScopeCustom, "a/b", "docker"),
+		"sanitized original": cache.GenerateScopedKey("a-b", cacheScopeCustom, "a-b", "docker"),
+		"long action 1":      cache.GenerateScopedKey("p", cacheScopeCwd, "/p", strings.Repeat("a", 60)+"1"),
+		"long action 2":      cache.GenerateScopedKey("p", cacheScopeCwd, "/p", strings.Repeat("a", 60)+"2"),
+	}
+
+	// スコープ・値・アクション名のどれかが異なれば必ず別のキーになる
+	seen := make(map[string]string)
+	for name, key := range keys {
+		if other, ok := seen[key]; ok {
+			t.Errorf("%s and %s share key %s", name, other, key)
+		}
+		seen[key] = name
+
+		if strings.ContainsAny(key, "/\\\x00") {
+			t.Errorf("%s key is not a safe file name: %q", name, key)
+		}
+	}
+}
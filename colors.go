@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -54,26 +57,375 @@ var bgColorMap = map[string]string{
 	"bg_bright_white":   "\033[107m",
 }
 
-// applyColor applies ANSI color codes to text
-func applyColor(text, color string) string {
-	if color == "" {
-		return text
+// colorDepth is the number of colors the terminal can display
+type colorDepth int
+
+const (
+	colorDepth16        colorDepth = 16
+	colorDepth256       colorDepth = 256
+	colorDepthTrueColor colorDepth = 1 << 24
+)
+
+// resolveColorDepth turns the color_depth setting into a colorDepth.
+// "auto" or "" detects it from COLORTERM and TERM.
+func resolveColorDepth(setting string) (colorDepth, error) {
+	switch setting {
+	case "truecolor", "24bit":
+		return colorDepthTrueColor, nil
+	case "256":
+		return colorDepth256, nil
+	case "16":
+		return colorDepth16, nil
+	case "", "auto":
+		colorTerm := os.Getenv("COLORTERM")
+		if colorTerm == "truecolor" || colorTerm == "24bit" {
+			return colorDepthTrueColor, nil
+		}
+		if strings.Contains(os.Getenv("TERM"), "256color") {
+			return colorDepth256, nil
+		}
+		return colorDepth16, nil
+	default:
+		return 0, fmt.Errorf("unknown color_depth %q (want auto, truecolor, 256 or 16)", setting)
 	}
+}
 
-	var colorCode string
+// colorKind tells how a termColor is specified
+type colorKind int
+
+const (
+	colorBasic colorKind = iota // One of the 16 named colors
+	color256                    // 256:N
+	colorRGB                    // #rrggbb or rgb(r, g, b)
+)
+
+// termColor is a parsed color value
+type termColor struct {
+	kind    colorKind
+	param   string // SGR parameter of a basic color, e.g. "31" or "101"
+	index   int    // Palette index of a 256 color
+	r, g, b int    // Components of an RGB color
+}
+
+// basicPalette holds the RGB values of the 16 basic colors (xterm defaults),
+// used to downgrade to 16 colors
+var basicPalette = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+var (
+	hexColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{2})([0-9a-fA-F]{2})([0-9a-fA-F]{2})$`)
+	rgbColorPattern = regexp.MustCompile(`^rgb\(\s*(\d{1,3})\s*,\s*(\d{1,3})\s*,\s*(\d{1,3})\s*\)$`)
+	xtermPattern    = regexp.MustCompile(`^256:(\d{1,3})$`)
+)
+
+// parseColor parses a color value: a named color (bg_ prefix for background),
+// #rrggbb, rgb(r, g, b) or 256:N, each optionally prefixed with bg_.
+// It reports whether the color is a background color.
+func parseColor(color string) (termColor, bool, error) {
+	if code, ok := colorMap[color]; ok {
+		return termColor{kind: colorBasic, param: sgrParam(code)}, false, nil
+	}
+	if code, ok := bgColorMap[color]; ok {
+		return termColor{kind: colorBasic, param: sgrParam(code)}, true, nil
+	}
+
+	spec, background := strings.CutPrefix(color, "bg_")
+
+	if m := hexColorPattern.FindStringSubmatch(spec); m != nil {
+		r, _ := strconv.ParseInt(m[1], 16, 0)
+		g, _ := strconv.ParseInt(m[2], 16, 0)
+		b, _ := strconv.ParseInt(m[3], 16, 0)
+		return termColor{kind: colorRGB, r: int(r), g: int(g), b: int(b)}, background, nil
+	}
 
-	// Check if it's a background color (starts with bg_)
-	if strings.HasPrefix(color, "bg_") {
-		colorCode = bgColorMap[color]
-	} else {
-		// It's a foreground color
-		colorCode = colorMap[color]
+	if m := rgbColorPattern.FindStringSubmatch(spec); m != nil {
+		var components [3]int
+		for i := range components {
+			components[i], _ = strconv.Atoi(m[i+1])
+			if components[i] > 255 {
+				return termColor{}, false, fmt.Errorf("color component out of range in %q", color)
+			}
+		}
+		return termColor{kind: colorRGB, r: components[0], g: components[1], b: components[2]}, background, nil
 	}
 
-	if colorCode == "" {
-		// Unknown color, return text as-is
+	if m := xtermPattern.FindStringSubmatch(spec); m != nil {
+		index, _ := strconv.Atoi(m[1])
+		if index > 255 {
+			return termColor{}, false, fmt.Errorf("color index out of range in %q", color)
+		}
+		return termColor{kind: color256, index: index}, background, nil
+	}
+
+	return termColor{}, false, fmt.Errorf("unknown color %q", color)
+}
+
+// sgrParam extracts the SGR parameter from an escape sequence like "\033[31m"
+func sgrParam(code string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(code, "\033["), "m")
+}
+
+// sgr returns the SGR parameter selecting c as foreground or background color,
+// downgraded to what depth can display
+func (c termColor) sgr(background bool, depth colorDepth) string {
+	switch c.kind {
+	case colorRGB:
+		switch depth {
+		case colorDepthTrueColor:
+			return fmt.Sprintf("%d;2;%d;%d;%d", extendedBase(background), c.r, c.g, c.b)
+		case colorDepth256:
+			return fmt.Sprintf("%d;5;%d", extendedBase(background), rgbTo256(c.r, c.g, c.b))
+		default:
+			return basicSGR(rgbTo16(c.r, c.g, c.b), background)
+		}
+	case color256:
+		if depth == colorDepth16 {
+			r, g, b := xterm256ToRGB(c.index)
+			return basicSGR(rgbTo16(r, g, b), background)
+		}
+		return fmt.Sprintf("%d;5;%d", extendedBase(background), c.index)
+	default:
+		return basicParam(c.param, background)
+	}
+}
+
+// basicParam converts the SGR parameter of a basic color between its
+// foreground (30-37, 90-97) and background (40-47, 100-107) form, so that
+// e.g. a background color can be reused as the foreground of a powerline glyph
+func basicParam(param string, background bool) string {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return param
+	}
+	isBackground := (n >= 40 && n <= 47) || (n >= 100 && n <= 107)
+	switch {
+	case background && !isBackground:
+		n += 10
+	case !background && isBackground:
+		n -= 10
+	}
+	return strconv.Itoa(n)
+}
+
+// extendedBase returns the SGR code introducing an extended foreground or background color
+func extendedBase(background bool) int {
+	if background {
+		return 48
+	}
+	return 38
+}
+
+// basicSGR returns the SGR parameter for basic color index 0-15
+func basicSGR(index int, background bool) string {
+	base := 30
+	if index >= 8 {
+		base = 90
+		index -= 8
+	}
+	if background {
+		base += 10
+	}
+	return strconv.Itoa(base + index)
+}
+
+// cubeLevels are the component values of the 6x6x6 color cube of the 256 color palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// rgbTo256 returns the nearest color of the 256 color cube or grayscale ramp
+func rgbTo256(r, g, b int) int {
+	nearestLevel := func(v int) int {
+		best := 0
+		for i, level := range cubeLevels {
+			if abs(v-level) < abs(v-cubeLevels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	ri, gi, bi := nearestLevel(r), nearestLevel(g), nearestLevel(b)
+	cube := 16 + 36*ri + 6*gi + bi
+	cubeDist := colorDistance(r, g, b, cubeLevels[ri], cubeLevels[gi], cubeLevels[bi])
+
+	// Grayscale ramp 232-255 covers 8, 18, ..., 238
+	gray := (r + g + b) / 3
+	grayIndex := (gray - 8 + 5) / 10
+	if grayIndex < 0 {
+		grayIndex = 0
+	} else if grayIndex > 23 {
+		grayIndex = 23
+	}
+	grayLevel := 8 + 10*grayIndex
+	if colorDistance(r, g, b, grayLevel, grayLevel, grayLevel) < cubeDist {
+		return 232 + grayIndex
+	}
+	return cube
+}
+
+// rgbTo16 returns the index of the nearest basic color
+func rgbTo16(r, g, b int) int {
+	best := 0
+	bestDist := -1
+	for i, c := range basicPalette {
+		if d := colorDistance(r, g, b, c[0], c[1], c[2]); bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// xterm256ToRGB returns the RGB value of a 256 color palette index
+func xterm256ToRGB(index int) (int, int, int) {
+	switch {
+	case index < 16:
+		c := basicPalette[index]
+		return c[0], c[1], c[2]
+	case index < 232:
+		index -= 16
+		return cubeLevels[index/36], cubeLevels[(index/6)%6], cubeLevels[index%6]
+	default:
+		level := 8 + 10*(index-232)
+		return level, level, level
+	}
+}
+
+// colorDistance returns the squared euclidean distance between two RGB colors
+func colorDistance(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// textStyle is a combination of foreground, background and text attributes
+type textStyle struct {
+	fg, bg    *termColor
+	bold      bool
+	dim       bool
+	italic    bool
+	underline bool
+}
+
+// styleAttributes maps attribute names to their setter and SGR parameter
+var styleAttributes = map[string]struct {
+	set   func(*textStyle)
+	param string
+}{
+	"bold":      {func(s *textStyle) { s.bold = true }, "1"},
+	"dim":       {func(s *textStyle) { s.dim = true }, "2"},
+	"italic":    {func(s *textStyle) { s.italic = true }, "3"},
+	"underline": {func(s *textStyle) { s.underline = true }, "4"},
+}
+
+// parseStyle parses a style spec such as "bold fg:white bg:red".
+// Tokens are attributes (bold, dim, italic, underline), fg:<color>,
+// bg:<color> or a plain color value as accepted by parseColor.
+// Later tokens override earlier ones.
+func parseStyle(spec string) (textStyle, error) {
+	var style textStyle
+
+	for _, token := range splitStyleTokens(spec) {
+		if attr, ok := styleAttributes[token]; ok {
+			attr.set(&style)
+			continue
+		}
+
+		color, explicitBg := token, false
+		if value, ok := strings.CutPrefix(token, "fg:"); ok {
+			color = value
+			if strings.HasPrefix(value, "bg_") {
+				return textStyle{}, fmt.Errorf("fg: takes a foreground color, got %q", value)
+			}
+		} else if value, ok := strings.CutPrefix(token, "bg:"); ok {
+			color, explicitBg = "bg_"+value, true
+			if strings.HasPrefix(value, "bg_") {
+				color = value
+			}
+		}
+
+		c, background, err := parseColor(color)
+		if err != nil {
+			return textStyle{}, err
+		}
+		if background || explicitBg {
+			style.bg = &c
+		} else {
+			style.fg = &c
+		}
+	}
+
+	return style, nil
+}
+
+// splitStyleTokens splits a style spec on whitespace outside parentheses,
+// so "bold rgb(1, 2, 3)" yields "bold" and "rgb(1, 2, 3)"
+func splitStyleTokens(spec string) []string {
+	var tokens []string
+	var current strings.Builder
+	depth := 0
+
+	for _, r := range spec {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case (r == ' ' || r == '\t') && depth == 0:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// sgr returns the combined SGR parameters of the style, e.g. "1;37;41"
+func (s textStyle) sgr(depth colorDepth) string {
+	var params []string
+	for _, attr := range []struct {
+		on   bool
+		name string
+	}{{s.bold, "bold"}, {s.dim, "dim"}, {s.italic, "italic"}, {s.underline, "underline"}} {
+		if attr.on {
+			params = append(params, styleAttributes[attr.name].param)
+		}
+	}
+	if s.fg != nil {
+		params = append(params, s.fg.sgr(false, depth))
+	}
+	if s.bg != nil {
+		params = append(params, s.bg.sgr(true, depth))
+	}
+	return strings.Join(params, ";")
+}
+
+// resetPattern matches SGR sequences that reset all attributes
+var resetPattern = regexp.MustCompile(`\x1b\[0*m`)
+
+// applyStyle wraps text in a single SGR sequence for style. Resets inside
+// text (e.g. from a command that colors its own output) are followed by the
+// style again, so the rest of the segment keeps it.
+func applyStyle(text string, style textStyle, depth colorDepth) string {
+	params := style.sgr(depth)
+	if params == "" {
 		return text
 	}
 
-	return fmt.Sprintf("%s%s%s", colorCode, text, resetCode)
+	code := "\033[" + params + "m"
+	text = resetPattern.ReplaceAllString(text, resetCode+code)
+	return code + text + resetCode
 }
@@ -4,12 +4,22 @@ import (
 	"testing"
 )
 
-func TestApplyColor(t *testing.T) {
+// styleText parses spec and applies it to text, as rendering does
+func styleText(text, spec string, depth colorDepth) (string, error) {
+	style, err := parseStyle(spec)
+	if err != nil {
+		return "", err
+	}
+	return applyStyle(text, style, depth), nil
+}
+
+func TestApplyStyleColors(t *testing.T) {
 	tests := []struct {
 		name     string
 		text     string
 		color    string
 		expected string
+		wantErr  bool
 	}{
 		{
 			name:     "cyan color",
@@ -48,10 +58,10 @@ func TestApplyColor(t *testing.T) {
 			expected: "No color",
 		},
 		{
-			name:     "unknown color",
-			text:     "Unknown",
-			color:    "invalid",
-			expected: "Unknown",
+			name:    "unknown color",
+			text:    "Unknown",
+			color:   "invalid",
+			wantErr: true,
 		},
 		{
 			name:     "bg_magenta background",
@@ -75,10 +85,152 @@ func TestApplyColor(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := applyColor(tt.text, tt.color)
+			result, err := styleText(tt.text, tt.color, colorDepthTrueColor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStyle(%q) error = %v, wantErr %v", tt.color, err, tt.wantErr)
+			}
 			if result != tt.expected {
-				t.Errorf("applyColor(%q, %q) = %q, want %q", tt.text, tt.color, result, tt.expected)
+				t.Errorf("applyStyle(%q, %q) = %q, want %q", tt.text, tt.color, result, tt.expected)
 			}
 		})
 	}
 }
+
+func TestApplyStyleDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		color    string
+		depth    colorDepth
+		expected string
+		wantErr  bool
+	}{
+		{name: "hex truecolor", color: "#ff8700", depth: colorDepthTrueColor, expected: "\033[38;2;255;135;0mX\033[0m"},
+		{name: "hex to 256", color: "#ff8700", depth: colorDepth256, expected: "\033[38;5;208mX\033[0m"},
+		{name: "hex to 16", color: "#ff0000", depth: colorDepth16, expected: "\033[91mX\033[0m"},
+		{name: "rgb truecolor", color: "rgb(1, 2, 3)", depth: colorDepthTrueColor, expected: "\033[38;2;1;2;3mX\033[0m"},
+		{name: "gray hex to 256 uses grayscale ramp", color: "#808080", depth: colorDepth256, expected: "\033[38;5;244mX\033[0m"},
+		{name: "bg hex truecolor", color: "bg_#102030", depth: colorDepthTrueColor, expected: "\033[48;2;16;32;48mX\033[0m"},
+		{name: "256 stays 256", color: "256:208", depth: colorDepthTrueColor, expected: "\033[38;5;208mX\033[0m"},
+		{name: "256 to 16", color: "256:196", depth: colorDepth16, expected: "\033[91mX\033[0m"},
+		{name: "bg 256 to 16", color: "bg_256:21", depth: colorDepth16, expected: "\033[44mX\033[0m"},
+		{name: "named color unaffected", color: "cyan", depth: colorDepth16, expected: "\033[36mX\033[0m"},
+		{name: "invalid hex", color: "#ff87", depth: colorDepthTrueColor, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := styleText("X", tt.color, tt.depth)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStyle(%q) error = %v, wantErr %v", tt.color, err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("applyStyle(%q) = %q, want %q", tt.color, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseColorErrors(t *testing.T) {
+	for _, color := range []string{"purple", "#12345g", "rgb(256, 0, 0)", "256:300", "bg_purple", "bg_"} {
+		t.Run(color, func(t *testing.T) {
+			if _, _, err := parseColor(color); err == nil {
+				t.Errorf("parseColor(%q) should fail", color)
+			}
+		})
+	}
+}
+
+func TestResolveColorDepth(t *testing.T) {
+	tests := []struct {
+		name      string
+		setting   string
+		colorTerm string
+		term      string
+		expected  colorDepth
+		wantErr   bool
+	}{
+		{name: "auto truecolor", colorTerm: "truecolor", term: "xterm-256color", expected: colorDepthTrueColor},
+		{name: "auto 24bit", setting: "auto", colorTerm: "24bit", expected: colorDepthTrueColor},
+		{name: "auto 256", term: "xterm-256color", expected: colorDepth256},
+		{name: "auto basic", term: "xterm", expected: colorDepth16},
+		{name: "explicit overrides env", setting: "16", colorTerm: "truecolor", expected: colorDepth16},
+		{name: "explicit 256", setting: "256", expected: colorDepth256},
+		{name: "explicit truecolor", setting: "truecolor", expected: colorDepthTrueColor},
+		{name: "invalid", setting: "88", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("COLORTERM", tt.colorTerm)
+			t.Setenv("TERM", tt.term)
+
+			result, err := resolveColorDepth(tt.setting)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveColorDepth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("resolveColorDepth(%q) = %v, want %v", tt.setting, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyStyleSpecs(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		spec     string
+		depth    colorDepth
+		expected string
+		wantErr  bool
+	}{
+		{name: "composite", text: "X", spec: "bold fg:white bg:red", depth: colorDepthTrueColor, expected: "\033[1;37;41mX\033[0m"},
+		{name: "attributes only", text: "X", spec: "italic underline", depth: colorDepthTrueColor, expected: "\033[3;4mX\033[0m"},
+		{name: "color name with attribute", text: "X", spec: "dim cyan", depth: colorDepthTrueColor, expected: "\033[2;36mX\033[0m"},
+		{name: "bg with bg_ prefix", text: "X", spec: "bg:bg_blue", depth: colorDepthTrueColor, expected: "\033[44mX\033[0m"},
+		{name: "rgb with spaces", text: "X", spec: "bold fg:rgb(1, 2, 3)", depth: colorDepthTrueColor, expected: "\033[1;38;2;1;2;3mX\033[0m"},
+		{name: "downgraded", text: "X", spec: "bold bg:#ff0000", depth: colorDepth16, expected: "\033[1;101mX\033[0m"},
+		{name: "later fg wins", text: "X", spec: "red fg:green", depth: colorDepthTrueColor, expected: "\033[32mX\033[0m"},
+		{
+			name:     "nested reset restores style",
+			text:     "a\033[31mb\033[0mc\033[md",
+			spec:     "bold bg:blue",
+			depth:    colorDepthTrueColor,
+			expected: "\033[1;44ma\033[31mb\033[0m\033[1;44mc\033[0m\033[1;44md\033[0m",
+		},
+		{name: "invalid fg", text: "X", spec: "fg:bg_red", depth: colorDepthTrueColor, wantErr: true},
+		{name: "unknown token", text: "X", spec: "bold blinking", depth: colorDepthTrueColor, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := styleText(tt.text, tt.spec, tt.depth)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStyle(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("applyStyle(%q, %q) = %q, want %q", tt.text, tt.spec, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBasicParam(t *testing.T) {
+	tests := []struct {
+		param      string
+		background bool
+		expected   string
+	}{
+		{param: "31", background: false, expected: "31"},
+		{param: "31", background: true, expected: "41"},
+		{param: "101", background: false, expected: "91"},
+		{param: "90", background: true, expected: "100"},
+		{param: "44", background: true, expected: "44"},
+	}
+
+	for _, tt := range tests {
+		if result := basicParam(tt.param, tt.background); result != tt.expected {
+			t.Errorf("basicParam(%q, %v) = %q, want %q", tt.param, tt.background, result, tt.expected)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/itchyny/gojq"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,24 +23,171 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	config, err := decodeConfig(data)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	config.path = path
+	if absPath, err := filepath.Abs(path); err == nil {
+		config.path = absPath
+	}
 
 	// Set default separator if not specified
 	if config.Separator == "" {
 		config.Separator = " | "
 	}
 
-	// Validate actions
-	if err := validateActions(config.Actions); err != nil {
-		return nil, err
+	if problems := checkConfig(config); len(problems) > 0 {
+		return nil, problems[0].err
 	}
 
+	return config, nil
+}
+
+// decodeConfig decodes a config strictly: unknown keys such as colour or
+// cache-ttl are errors. On a *yaml.TypeError the returned config holds
+// everything that could be decoded.
+func decodeConfig(data []byte) (*Config, error) {
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return &config, err
+	}
 	return &config, nil
 }
 
+// configProblem is a problem found in a config. It is located by the YAML
+// path of the offending value, so `ccstatusline validate` can report its position.
+type configProblem struct {
+	action int    // Index in allActions() the path is relative to, or -1 for the whole document
+	path   string // Dotted mapping keys and sequence indexes, e.g. "color_rules.0.when"
+	err    error
+}
+
+// firstError returns the error of the first problem, if any
+func firstError(problems []configProblem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems[0].err
+}
+
+// checkConfig returns every problem of a decoded config in the order they are reported
+func checkConfig(config *Config) []configProblem {
+	var problems []configProblem
+	add := func(path string, err error) {
+		problems = append(problems, configProblem{action: -1, path: path, err: err})
+	}
+
+	if config.Concurrency < 0 {
+		add("concurrency", fmt.Errorf("concurrency must not be negative: %d", config.Concurrency))
+	}
+	if _, err := resolveColorDepth(config.ColorDepth); err != nil {
+		add("color_depth", err)
+	}
+	switch config.Layout {
+	case "", layoutPlain, layoutPowerline:
+	default:
+		add("layout", fmt.Errorf("unknown layout %q (want %s or %s)", config.Layout, layoutPlain, layoutPowerline))
+	}
+	if config.MaxWidth < 0 {
+		add("max_width", fmt.Errorf("max_width must not be negative: %d", config.MaxWidth))
+	}
+	if config.ContextWindow < 0 {
+		add("context_window", fmt.Errorf("context_window must not be negative: %d", config.ContextWindow))
+	}
+	problems = append(problems, costProblems(config.Cost)...)
+	if _, err := parseLogLevel(config.LogLevel); err != nil {
+		add("log_level", err)
+	}
+	if config.Timeout < 0 {
+		add("timeout", fmt.Errorf("timeout must not be negative: %s", config.Timeout))
+	}
+
+	problems = append(problems, lineProblems(config)...)
+
+	// Names are unique across all lines
+	problems = append(problems, actionProblems(config.allActions())...)
+
+	if config.StrictQuoting {
+		problems = append(problems, quotingProblems(config.allActions())...)
+	}
+
+	return problems
+}
+
+// lineProblems checks the multi-line form of the config
+func lineProblems(config *Config) []configProblem {
+	var problems []configProblem
+	add := func(path string, err error) {
+		problems = append(problems, configProblem{action: -1, path: path, err: err})
+	}
+
+	if len(config.Lines) > 0 && len(config.Actions) > 0 {
+		add("lines", fmt.Errorf("actions and lines are mutually exclusive"))
+	}
+
+	for i, line := range config.Lines {
+		if len(line.Actions) == 0 {
+			add(fmt.Sprintf("lines.%d", i), fmt.Errorf("line %d: actions is required", i+1))
+		}
+		switch line.Align {
+		case "", alignLeft, alignRight, alignCenter:
+		default:
+			add(fmt.Sprintf("lines.%d.align", i), fmt.Errorf("line %d: unknown align %q (want %s, %s or %s)", i+1, line.Align, alignLeft, alignRight, alignCenter))
+		}
+	}
+	return problems
+}
+
+// costProblems checks the cost estimation settings
+func costProblems(cost CostConfig) []configProblem {
+	var problems []configProblem
+	add := func(path string, err error) {
+		problems = append(problems, configProblem{action: -1, path: path, err: err})
+	}
+
+	if err := validateCostFormat(cost.Format); err != nil {
+		add("cost.format", err)
+	}
+	if cost.Rate < 0 {
+		add("cost.rate", fmt.Errorf("cost.rate must not be negative: %v", cost.Rate))
+	}
+	models := make([]string, 0, len(cost.Pricing))
+	for model := range cost.Pricing {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		price := cost.Pricing[model]
+		if price.Input < 0 || price.Output < 0 || price.CacheRead < 0 || price.CacheWrite < 0 {
+			add("cost.pricing."+model, fmt.Errorf("cost.pricing.%s: prices must not be negative", model))
+		}
+	}
+	return problems
+}
+
+// lines returns the lines of the statusline; the flat actions form is a single line
+func (c *Config) lines() []Line {
+	if len(c.Lines) > 0 {
+		return c.Lines
+	}
+	return []Line{{Actions: c.Actions}}
+}
+
+// allActions returns the actions of all lines in order
+func (c *Config) allActions() []Action {
+	if len(c.Lines) == 0 {
+		return c.Actions
+	}
+	var actions []Action
+	for _, line := range c.Lines {
+		actions = append(actions, line.Actions...)
+	}
+	return actions
+}
+
 // resolveConfigPath resolves the configuration file path
 func resolveConfigPath(configPath string) string {
 	// If explicit path is provided, use it
@@ -59,27 +212,260 @@ func resolveConfigPath(configPath string) string {
 	return "config.yaml"
 }
 
-// validateActions validates the action configuration
-func validateActions(actions []Action) error {
+// actionProblems checks each action, then the dependencies between them
+func actionProblems(actions []Action) []configProblem {
+	var problems []configProblem
 	names := make(map[string]bool)
 
 	for i, action := range actions {
+		label := actionLabel(i, action)
+		add := func(path string, format string, args ...interface{}) {
+			err := fmt.Errorf("action %s: "+format, append([]interface{}{label}, args...)...)
+			problems = append(problems, configProblem{action: i, path: path, err: err})
+		}
+
 		// Check name is required
 		if action.Name == "" {
-			return fmt.Errorf("action at index %d: name is required", i)
+			add("name", "name is required")
 		}
 
 		// Check for duplicate names
-		if names[action.Name] {
-			return fmt.Errorf("duplicate action name: %s", action.Name)
+		if action.Name != "" && names[action.Name] {
+			problems = append(problems, configProblem{action: i, path: "name", err: fmt.Errorf("duplicate action name: %s", action.Name)})
 		}
 		names[action.Name] = true
 
-		// Check command is required
-		if action.Command == "" {
-			return fmt.Errorf("action %s: command is required", action.Name)
+		// Check exactly one of command or template is set
+		if action.Command == "" && action.Template == "" {
+			add("", "command or template is required")
+		}
+		if action.Command != "" && action.Template != "" {
+			add("template", "command and template are mutually exclusive")
+		}
+
+		// Template actions never run a process, so there is nothing to cache or time out
+		if action.Template != "" && (action.CacheTTL != 0 || action.Timeout != 0) {
+			path := "cache_ttl"
+			if action.CacheTTL == 0 {
+				path = "timeout"
+			}
+			add(path, "cache_ttl and timeout only apply to command actions")
 		}
+
+		if _, err := parseStyle(actionStyleSpec(action)); err != nil {
+			path := "style"
+			if _, colorErr := parseStyle(action.Color); colorErr != nil {
+				path = "color"
+			}
+			add(path, "%w", err)
+		}
+
+		for j, rule := range action.ColorRules {
+			rulePath := fmt.Sprintf("color_rules.%d", j)
+			if rule.When == "" {
+				add(rulePath, "color_rules[%d]: when is required", j)
+			} else if _, err := gojq.Parse(rule.When); err != nil {
+				add(rulePath+".when", "color_rules[%d]: invalid when expression: %w", j, err)
+			}
+			if _, err := parseStyle(string(rule.Style)); err != nil {
+				add(rulePath+".style", "color_rules[%d]: %w", j, err)
+			}
+		}
+
+		if action.When != "" {
+			if _, err := gojq.Parse(action.When); err != nil {
+				add("when", "invalid when expression: %w", err)
+			}
+		}
+
+		if action.Timeout < 0 {
+			add("timeout", "timeout must not be negative: %s", action.Timeout)
+		}
+		if action.MaxWidth < 0 {
+			add("max_width", "max_width must not be negative: %d", action.MaxWidth)
+		}
+
+		switch action.CacheScope {
+		case "", cacheScopeGlobal, cacheScopeCwd, cacheScopeProjectDir, cacheScopeSessionID, cacheScopeGitHead:
+		default:
+			add("cache_scope", "unknown cache_scope %q", action.CacheScope)
+		}
+		if action.CacheScope != "" && action.CacheKey != "" {
+			add("cache_key", "cache_scope and cache_key are mutually exclusive")
+		}
+
+		switch action.OnError {
+		case "", onErrorHide, onErrorStderr:
+		case onErrorPlaceholder:
+			if action.Placeholder == "" {
+				add("on_error", "on_error placeholder requires placeholder")
+			}
+		case onErrorCached:
+			if action.CacheTTL <= 0 {
+				add("on_error", "on_error cached requires cache_ttl")
+			}
+		default:
+			add("on_error", "unknown on_error %q (want %s, %s, %s or %s)", action.OnError, onErrorHide, onErrorPlaceholder, onErrorStderr, onErrorCached)
+		}
+		if action.Template != "" && action.OnError != "" {
+			add("on_error", "on_error only applies to command actions")
+		}
+		if _, err := parseStyle(string(action.ErrorStyle)); err != nil {
+			add("error_style", "error_style: %w", err)
+		}
+
+		if action.StaleTTL != 0 {
+			if action.CacheTTL <= 0 {
+				add("stale_ttl", "stale_ttl requires cache_ttl")
+			} else if action.StaleTTL < 0 || time.Duration(action.StaleTTL)*time.Second > staleRetention {
+				add("stale_ttl", "stale_ttl must be between 0 and %d", int(staleRetention.Seconds()))
+			}
+		}
+	}
+
+	return append(problems, dependencyProblems(actions)...)
+}
+
+// actionLabel names an action in messages, by index when it has no name
+func actionLabel(i int, action Action) string {
+	if action.Name == "" {
+		return fmt.Sprintf("at index %d", i)
 	}
+	return action.Name
+}
+
+// dependencyProblems checks that depends_on only names existing actions
+// and that dependencies don't form a cycle
+func dependencyProblems(actions []Action) []configProblem {
+	var problems []configProblem
+	index := actionIndex(actions)
+	for i, action := range actions {
+		for j, dep := range action.DependsOn {
+			if _, ok := index[dep]; !ok {
+				problems = append(problems, configProblem{
+					action: i,
+					path:   fmt.Sprintf("depends_on.%d", j),
+					err:    fmt.Errorf("action %s: depends_on unknown action %s", actionLabel(i, action), dep),
+				})
+			}
+		}
+	}
+	if len(problems) > 0 {
+		// Cycles can only be followed through known actions
+		return problems
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(actions))
 
+	var visit func(name string, path []string) *configProblem
+	visit = func(name string, path []string) *configProblem {
+		switch state[name] {
+		case visiting:
+			// Report only the part of the path that forms the cycle
+			for i, n := range path {
+				if n == name {
+					path = path[i:]
+					break
+				}
+			}
+			return &configProblem{
+				action: index[name],
+				path:   "depends_on",
+				err:    fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> ")),
+			}
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range actions[index[name]].DependsOn {
+			if problem := visit(dep, append(path, name)); problem != nil {
+				return problem
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+
+	for _, action := range actions {
+		if problem := visit(action.Name, nil); problem != nil {
+			return []configProblem{*problem}
+		}
+	}
+	return nil
+}
+
+// quotingProblems rejects commands that paste template values into the shell unquoted
+func quotingProblems(actions []Action) []configProblem {
+	var problems []configProblem
+	for i, action := range actions {
+		if unquoted := unquotedPlaceholders(action.Command); len(unquoted) > 0 {
+			problems = append(problems, configProblem{
+				action: i,
+				path:   "command",
+				err:    fmt.Errorf("action %s: unquoted template %s in command (use {@sh ...} or disable strict_quoting)", actionLabel(i, action), unquoted[0]),
+			})
+		}
+	}
+	return problems
+}
+
+// UnmarshalYAML accepts a style either as a spec string or as a mapping,
+// which is converted to the equivalent spec string
+func (s *StyleSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = StyleSpec(node.Value)
+		return nil
+	}
+
+	// node.Decode ignores unknown keys, so check them here like KnownFields does
+	if node.Kind == yaml.MappingNode {
+		var unknown []string
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			switch key.Value {
+			case "fg", "bg", "bold", "dim", "italic", "underline":
+			default:
+				unknown = append(unknown, fmt.Sprintf("line %d: field %s not found in type main.StyleSpec", key.Line, key.Value))
+			}
+		}
+		if len(unknown) > 0 {
+			return &yaml.TypeError{Errors: unknown}
+		}
+	}
+
+	var m struct {
+		Fg        string `yaml:"fg"`
+		Bg        string `yaml:"bg"`
+		Bold      bool   `yaml:"bold"`
+		Dim       bool   `yaml:"dim"`
+		Italic    bool   `yaml:"italic"`
+		Underline bool   `yaml:"underline"`
+	}
+	if err := node.Decode(&m); err != nil {
+		return err
+	}
+
+	var tokens []string
+	for _, attr := range []struct {
+		on   bool
+		name string
+	}{{m.Bold, "bold"}, {m.Dim, "dim"}, {m.Italic, "italic"}, {m.Underline, "underline"}} {
+		if attr.on {
+			tokens = append(tokens, attr.name)
+		}
+	}
+	if m.Fg != "" {
+		tokens = append(tokens, "fg:"+m.Fg)
+	}
+	if m.Bg != "" {
+		tokens = append(tokens, "bg:"+m.Bg)
+	}
+	*s = StyleSpec(strings.Join(tokens, " "))
 	return nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -139,3 +140,420 @@ func TestResolveConfigPath(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateActionsCacheScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  Action
+		wantErr bool
+	}{
+		{name: "default scope", action: Action{Name: "a", Command: "true"}},
+		{name: "git_head scope", action: Action{Name: "a", Command: "true", CacheScope: "git_head"}},
+		{name: "custom key", action: Action{Name: "a", Command: "true", CacheKey: "{.session_id}"}},
+		{name: "unknown scope", action: Action{Name: "a", Command: "true", CacheScope: "machine"}, wantErr: true},
+		{name: "scope and key", action: Action{Name: "a", Command: "true", CacheScope: "global", CacheKey: "x"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := firstError(actionProblems([]Action{tt.action}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("actionProblems() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateActionsWhen(t *testing.T) {
+	if err := firstError(actionProblems([]Action{{Name: "a", Command: "true", When: ".cwd != null"}})); err != nil {
+		t.Errorf("actionProblems() error = %v", err)
+	}
+	if err := firstError(actionProblems([]Action{{Name: "a", Command: "true", When: ".cwd !="}})); err == nil {
+		t.Error("Expected error for invalid when expression")
+	}
+}
+
+func TestValidateActionsCommandOrTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  Action
+		wantErr bool
+	}{
+		{name: "command only", action: Action{Name: "a", Command: "true"}},
+		{name: "template only", action: Action{Name: "a", Template: "{.cwd}"}},
+		{name: "neither", action: Action{Name: "a"}, wantErr: true},
+		{name: "both", action: Action{Name: "a", Command: "true", Template: "{.cwd}"}, wantErr: true},
+		{name: "template with cache_ttl", action: Action{Name: "a", Template: "{.cwd}", CacheTTL: 10}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := firstError(actionProblems([]Action{tt.action}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("actionProblems() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigStrictQuoting(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "quoted placeholders",
+			content: `strict_quoting: true
+actions:
+  - name: dir
+    command: "ls {@sh .cwd}"
+  - name: model
+    template: "{.model.display_name}"`,
+		},
+		{
+			name: "unquoted placeholder",
+			content: `strict_quoting: true
+actions:
+  - name: dir
+    command: "ls {.cwd}"`,
+			wantErr: true,
+		},
+		{
+			name: "unquoted placeholder without strict mode",
+			content: `actions:
+  - name: dir
+    command: "ls {.cwd}"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateActionsDependsOn(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []Action
+		wantErr string
+	}{
+		{
+			name: "chain",
+			actions: []Action{
+				{Name: "a", Command: "true"},
+				{Name: "b", Command: "true", DependsOn: []string{"a"}},
+				{Name: "c", Command: "true", DependsOn: []string{"a", "b"}},
+			},
+		},
+		{
+			name: "unknown dependency",
+			actions: []Action{
+				{Name: "a", Command: "true", DependsOn: []string{"missing"}},
+			},
+			wantErr: "depends_on unknown action missing",
+		},
+		{
+			name: "self dependency",
+			actions: []Action{
+				{Name: "a", Command: "true", DependsOn: []string{"a"}},
+			},
+			wantErr: "dependency cycle: a -> a",
+		},
+		{
+			name: "cycle",
+			actions: []Action{
+				{Name: "root", Command: "true", DependsOn: []string{"a"}},
+				{Name: "a", Command: "true", DependsOn: []string{"b"}},
+				{Name: "b", Command: "true", DependsOn: []string{"c"}},
+				{Name: "c", Command: "true", DependsOn: []string{"a"}},
+			},
+			wantErr: "dependency cycle: a -> b -> c -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := firstError(actionProblems(tt.actions))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("actionProblems() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("actionProblems() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateActionsColor(t *testing.T) {
+	for _, color := range []string{"cyan", "bg_red", "#00ff00", "rgb(0, 255, 0)", "256:42", "bg_#000000"} {
+		if err := firstError(actionProblems([]Action{{Name: "a", Command: "true", Color: color}})); err != nil {
+			t.Errorf("actionProblems() with color %q error = %v", color, err)
+		}
+	}
+
+	err := firstError(actionProblems([]Action{{Name: "a", Command: "true", Color: "purple"}}))
+	if err == nil || !strings.Contains(err.Error(), `unknown color "purple"`) {
+		t.Errorf("actionProblems() error = %v, want unknown color error", err)
+	}
+}
+
+func TestLoadConfigStyle(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	content := `actions:
+  - name: inline
+    command: "echo a"
+    style: "bold fg:white bg:red"
+  - name: mapping
+    command: "echo b"
+    style:
+      fg: "#ffffff"
+      bg: red
+      bold: true
+      underline: true
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if got := config.Actions[0].Style; got != "bold fg:white bg:red" {
+		t.Errorf("inline style = %q", got)
+	}
+	if got := config.Actions[1].Style; got != "bold underline fg:#ffffff bg:red" {
+		t.Errorf("mapping style = %q", got)
+	}
+}
+
+func TestValidateActionsStyle(t *testing.T) {
+	if err := firstError(actionProblems([]Action{{Name: "a", Command: "true", Color: "cyan", Style: "bold bg:#000000"}})); err != nil {
+		t.Errorf("actionProblems() error = %v", err)
+	}
+	for _, style := range []StyleSpec{"bold fg:purple", "blinking", "fg:bg_red"} {
+		if err := firstError(actionProblems([]Action{{Name: "a", Command: "true", Style: style}})); err == nil {
+			t.Errorf("actionProblems() with style %q should fail", style)
+		}
+	}
+}
+
+func TestLoadConfigLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		layout  string
+		wantErr bool
+	}{
+		{name: "unset", layout: ""},
+		{name: "plain", layout: "plain"},
+		{name: "powerline", layout: "powerline"},
+		{name: "unknown", layout: "tmux", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			content := "layout: \"" + tt.layout + "\"\npowerline:\n  left_cap: \"(\"\nactions:\n  - name: a\n    template: x\n"
+			if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(configPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && config.Powerline.LeftCap != "(" {
+				t.Errorf("Powerline.LeftCap = %q, want %q", config.Powerline.LeftCap, "(")
+			}
+		})
+	}
+}
+
+func TestValidateActionsColorRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []ColorRule
+		wantErr bool
+	}{
+		{name: "valid", rules: []ColorRule{{When: ". > 80", Style: "bold red"}, {When: "true", Style: ""}}},
+		{name: "missing when", rules: []ColorRule{{Style: "red"}}, wantErr: true},
+		{name: "invalid when", rules: []ColorRule{{When: ". >", Style: "red"}}, wantErr: true},
+		{name: "invalid style", rules: []ColorRule{{When: "true", Style: "fg:purple"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := firstError(actionProblems([]Action{{Name: "a", Command: "true", ColorRules: tt.rules}}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("actionProblems() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "lines",
+			content: `lines:
+  - actions:
+      - name: model
+        template: "{.model.display_name}"
+  - separator: " / "
+    align: right
+    actions:
+      - name: dir
+        template: "{.cwd}"
+`,
+		},
+		{
+			name: "both actions and lines",
+			content: `actions:
+  - name: a
+    template: a
+lines:
+  - actions:
+      - name: b
+        template: b
+`,
+			wantErr: "mutually exclusive",
+		},
+		{
+			name: "duplicate names across lines",
+			content: `lines:
+  - actions:
+      - name: a
+        template: a
+  - actions:
+      - name: a
+        template: b
+`,
+			wantErr: "duplicate action name: a",
+		},
+		{
+			name:    "empty line",
+			content: "lines:\n  - separator: x\n",
+			wantErr: "line 1: actions is required",
+		},
+		{
+			name:    "unknown align",
+			content: "lines:\n  - align: middle\n    actions:\n      - name: a\n        template: a\n",
+			wantErr: `line 1: unknown align "middle"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(configPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if got := len(config.allActions()); got != 2 {
+				t.Errorf("allActions() has %d actions, want 2", got)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "top level", content: "colour_depth: 256\nactions:\n  - name: a\n    template: a\n", wantErr: "field colour_depth not found"},
+		{name: "action", content: "actions:\n  - name: a\n    command: date\n    cache-ttl: 5\n", wantErr: "field cache-ttl not found"},
+		{name: "style mapping", content: "actions:\n  - name: a\n    template: a\n    style: {fg: red, blink: true}\n", wantErr: "field blink not found"},
+		{name: "empty file", content: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadConfig(configPath)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("LoadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateActionsOnError(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  Action
+		wantErr bool
+	}{
+		{name: "hide", action: Action{Name: "a", Command: "true", OnError: "hide"}},
+		{name: "stderr with style", action: Action{Name: "a", Command: "true", OnError: "stderr", ErrorStyle: "bold red"}},
+		{name: "placeholder", action: Action{Name: "a", Command: "true", OnError: "placeholder", Placeholder: "?"}},
+		{name: "placeholder missing", action: Action{Name: "a", Command: "true", OnError: "placeholder"}, wantErr: true},
+		{name: "cached", action: Action{Name: "a", Command: "true", OnError: "cached", CacheTTL: 60}},
+		{name: "cached without cache_ttl", action: Action{Name: "a", Command: "true", OnError: "cached"}, wantErr: true},
+		{name: "unknown", action: Action{Name: "a", Command: "true", OnError: "retry"}, wantErr: true},
+		{name: "template", action: Action{Name: "a", Template: "{.cwd}", OnError: "hide"}, wantErr: true},
+		{name: "invalid error_style", action: Action{Name: "a", Command: "true", ErrorStyle: "purple"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := firstError(actionProblems([]Action{tt.action}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("actionProblems() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLogLevel(t *testing.T) {
+	for level, wantErr := range map[string]bool{"": false, "debug": false, "off": false, "verbose": true} {
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		content := "log_level: \"" + level + "\"\nactions:\n  - name: a\n    template: a\n"
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(configPath); (err != nil) != wantErr {
+			t.Errorf("LoadConfig() with log_level %q error = %v, wantErr %v", level, err, wantErr)
+		}
+	}
+}
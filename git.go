@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gitRepo locates the directories of a git repository
+type gitRepo struct {
+	workTree  string // Top-level directory of the working tree
+	gitDir    string // Per-worktree git dir (HEAD, index, rebase state)
+	commonDir string // Shared git dir (objects, refs, packed-refs, config)
+
+	packs []*gitPack // Pack files, loaded on first object lookup
+}
+
+// findGitRepo walks up from dir looking for a .git directory or a .git file
+// pointing elsewhere (linked worktrees and submodules)
+func findGitRepo(dir string) (*gitRepo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		info, err := os.Stat(dotGit)
+		if err == nil {
+			gitDir := dotGit
+			if !info.IsDir() {
+				gitDir, err = readGitDirFile(dotGit)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return &gitRepo{
+				workTree:  dir,
+				gitDir:    gitDir,
+				commonDir: resolveCommonDir(gitDir),
+			}, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("not a git repository")
+		}
+		dir = parent
+	}
+}
+
+// readGitDirFile reads a "gitdir: <path>" file as written for worktrees and submodules
+func readGitDirFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	content := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(content, "gitdir:") {
+		return "", fmt.Errorf("invalid .git file: %s", path)
+	}
+
+	gitDir := strings.TrimSpace(strings.TrimPrefix(content, "gitdir:"))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	return filepath.Clean(gitDir), nil
+}
+
+// resolveCommonDir follows the commondir file of a linked worktree
+func resolveCommonDir(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+
+	commonDir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(gitDir, commonDir)
+	}
+	return filepath.Clean(commonDir)
+}
+
+// head returns the symbolic ref HEAD points to ("" when detached) and the commit it resolves to
+func (r *gitRepo) head() (ref string, sha string, err error) {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "HEAD"))
+	if err != nil {
+		return "", "", err
+	}
+
+	content := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(content, "ref:") {
+		return "", content, nil
+	}
+
+	ref = strings.TrimSpace(strings.TrimPrefix(content, "ref:"))
+	sha, err = r.resolveRef(ref)
+	if err != nil {
+		// Unborn branch: HEAD points to a ref that doesn't exist yet
+		return ref, "", nil
+	}
+	return ref, sha, nil
+}
+
+// resolveRef resolves a ref like refs/heads/main to a commit SHA using loose refs and packed-refs
+func (r *gitRepo) resolveRef(ref string) (string, error) {
+	for depth := 0; depth < 10; depth++ {
+		dir := r.commonDir
+		// Per-worktree refs live in the worktree's own git dir
+		if !strings.HasPrefix(ref, "refs/") || strings.HasPrefix(ref, "refs/bisect/") || strings.HasPrefix(ref, "refs/worktree/") {
+			dir = r.gitDir
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(ref)))
+		if err != nil {
+			return r.resolvePackedRef(ref)
+		}
+
+		content := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(content, "ref:") {
+			return content, nil
+		}
+		ref = strings.TrimSpace(strings.TrimPrefix(content, "ref:"))
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs")
+}
+
+// resolvePackedRef looks up ref in the packed-refs file
+func (r *gitRepo) resolvePackedRef(ref string) (string, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "packed-refs"))
+	if err != nil {
+		return "", fmt.Errorf("ref %s not found", ref)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		sha, name, ok := strings.Cut(line, " ")
+		if ok && name == ref {
+			return sha, nil
+		}
+	}
+	return "", fmt.Errorf("ref %s not found", ref)
+}
+
+// resolveGitHead returns the commit HEAD points to for the repository containing dir
+func resolveGitHead(dir string) (string, error) {
+	repo, err := findGitRepo(dir)
+	if err != nil {
+		return "", err
+	}
+	_, sha, err := repo.head()
+	return sha, err
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Index entry flags
+const (
+	indexStageMask    = 0x3000 // Merge stage; non-zero for conflicted paths
+	indexExtended     = 0x4000 // An extended flags field follows (version 3+)
+	indexSkipWorktree = 0x4000 // Extended flag: sparse checkout, not in the worktree
+	indexIntentToAdd  = 0x2000 // Extended flag: git add -N
+)
+
+// gitIndexEntry is a path staged in the index
+type gitIndexEntry struct {
+	path         string
+	sha          string
+	mode         uint32
+	size         uint32
+	mtimeSec     uint32
+	mtimeNsec    uint32
+	stage        int
+	skipWorktree bool
+	intentToAdd  bool
+}
+
+// gitCacheTree is a directory of the index's cache-tree extension: the tree
+// object its entries were last written as, kept by git so that unchanged
+// directories needn't be hashed again
+type gitCacheTree struct {
+	entries  int    // Index entries below the directory, -1 once an entry has changed
+	sha      string // Tree object of the directory, if valid
+	children map[string]*gitCacheTree
+}
+
+// valid reports whether the directory still matches its recorded tree
+func (t *gitCacheTree) valid() bool {
+	return t != nil && t.entries >= 0
+}
+
+// lookup returns the node of dir ("a/b/", "" for the root), or nil if the
+// cache-tree has none
+func (t *gitCacheTree) lookup(dir string) *gitCacheTree {
+	node := t
+	for _, name := range strings.Split(strings.TrimSuffix(dir, "/"), "/") {
+		if node == nil || name == "" {
+			break
+		}
+		node = node.children[name]
+	}
+	return node
+}
+
+// readIndex reads the entries of the worktree's index (versions 2 to 4) and
+// its cache-tree, which is nil if the index has none. A missing index (fresh
+// repository) has no entries.
+func (r *gitRepo) readIndex() ([]gitIndexEntry, *gitCacheTree, error) {
+	f, err := os.Open(filepath.Join(r.gitDir, "index"))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var header struct {
+		Signature [4]byte
+		Version   uint32
+		Count     uint32
+	}
+	if err := binary.Read(reader, binary.BigEndian, &header); err != nil {
+		return nil, nil, err
+	}
+	if string(header.Signature[:]) != "DIRC" || header.Version < 2 || header.Version > 4 {
+		return nil, nil, fmt.Errorf("unsupported index format")
+	}
+
+	entries := make([]gitIndexEntry, 0, header.Count)
+	previous := ""
+	for i := uint32(0); i < header.Count; i++ {
+		var fixed struct {
+			CtimeSec, CtimeNsec uint32
+			MtimeSec, MtimeNsec uint32
+			Dev, Ino            uint32
+			Mode                uint32
+			UID, GID            uint32
+			Size                uint32
+			SHA                 [20]byte
+			Flags               uint16
+		}
+		if err := binary.Read(reader, binary.BigEndian, &fixed); err != nil {
+			return nil, nil, err
+		}
+		entryLen := 62
+
+		var extended uint16
+		if fixed.Flags&indexExtended != 0 && header.Version >= 3 {
+			if err := binary.Read(reader, binary.BigEndian, &extended); err != nil {
+				return nil, nil, err
+			}
+			entryLen += 2
+		}
+
+		var path string
+		if header.Version == 4 {
+			// The path shares a prefix with the previous one: strip N bytes, then append
+			strip, err := readOffsetVarint(reader)
+			if err != nil {
+				return nil, nil, err
+			}
+			if strip > uint64(len(previous)) {
+				return nil, nil, fmt.Errorf("corrupt index")
+			}
+			suffix, err := reader.ReadBytes(0)
+			if err != nil {
+				return nil, nil, err
+			}
+			path = previous[:len(previous)-int(strip)] + string(suffix[:len(suffix)-1])
+		} else {
+			name, err := reader.ReadBytes(0)
+			if err != nil {
+				return nil, nil, err
+			}
+			path = string(name[:len(name)-1])
+			// Entries are NUL-padded to a multiple of 8 bytes
+			entryLen += len(name)
+			if padding := (8 - entryLen%8) % 8; padding > 0 {
+				if _, err := io.CopyN(io.Discard, reader, int64(padding)); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		previous = path
+
+		entries = append(entries, gitIndexEntry{
+			path:         path,
+			sha:          hex.EncodeToString(fixed.SHA[:]),
+			mode:         fixed.Mode,
+			size:         fixed.Size,
+			mtimeSec:     fixed.MtimeSec,
+			mtimeNsec:    fixed.MtimeNsec,
+			stage:        int(fixed.Flags&indexStageMask) >> 12,
+			skipWorktree: extended&indexSkipWorktree != 0,
+			intentToAdd:  extended&indexIntentToAdd != 0,
+		})
+	}
+
+	// Extensions follow the entries, then a checksum. The cache-tree is only
+	// an optimization, so an index it can't be read from is used without it.
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries, readCacheTreeExtension(rest), nil
+}
+
+// readCacheTreeExtension finds and parses the TREE extension in the data
+// following the index entries
+func readCacheTreeExtension(data []byte) *gitCacheTree {
+	// The trailing checksum
+	if len(data) < sha1.Size {
+		return nil
+	}
+	data = data[:len(data)-sha1.Size]
+
+	for len(data) >= 8 {
+		signature := string(data[:4])
+		size := binary.BigEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return nil
+		}
+		if signature == "TREE" {
+			_, tree, _, err := parseCacheTree(data[8 : 8+size])
+			if err != nil {
+				return nil
+			}
+			return tree
+		}
+		data = data[8+size:]
+	}
+	return nil
+}
+
+// parseCacheTree parses a cache-tree node and its subtrees, which follow it
+// depth first: "<name>\x00<entry count> <subtree count>\n", then the 20-byte
+// tree object unless the entry count is -1. It returns the node's name, the
+// node and the data after it.
+func parseCacheTree(data []byte) (string, *gitCacheTree, []byte, error) {
+	name, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return "", nil, nil, fmt.Errorf("corrupt cache-tree")
+	}
+	header, rest, ok := bytes.Cut(rest, []byte{'\n'})
+	if !ok {
+		return "", nil, nil, fmt.Errorf("corrupt cache-tree")
+	}
+	countText, subtreesText, _ := strings.Cut(string(header), " ")
+	entries, err := strconv.Atoi(countText)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("corrupt cache-tree")
+	}
+	subtrees, err := strconv.Atoi(subtreesText)
+	if err != nil || subtrees < 0 {
+		return "", nil, nil, fmt.Errorf("corrupt cache-tree")
+	}
+
+	node := &gitCacheTree{entries: entries, children: make(map[string]*gitCacheTree, subtrees)}
+	if node.valid() {
+		if len(rest) < 20 {
+			return "", nil, nil, fmt.Errorf("corrupt cache-tree")
+		}
+		node.sha = hex.EncodeToString(rest[:20])
+		rest = rest[20:]
+	}
+	for i := 0; i < subtrees; i++ {
+		var childName string
+		var child *gitCacheTree
+		if childName, child, rest, err = parseCacheTree(rest); err != nil {
+			return "", nil, nil, err
+		}
+		node.children[childName] = child
+	}
+	return string(name), node, rest, nil
+}
+
+// isGitlink reports whether mode is that of a submodule entry
+func isGitlink(mode uint32) bool {
+	return mode&0o170000 == 0o160000
+}
+
+// hashBlob returns the object name git gives to content stored as a blob
+func hashBlob(content []byte) string {
+	return hashObject("blob", content)
+}
+
+// readFileForHash returns what git hashes for the file at path: the target of
+// a symlink, the contents otherwise
+func readFileForHash(path string, info os.FileInfo) ([]byte, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		return []byte(target), err
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object types as stored in pack files
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+// packTypeNames maps pack object types to their names
+var packTypeNames = map[int]string{
+	packCommit: "commit",
+	packTree:   "tree",
+	packBlob:   "blob",
+	packTag:    "tag",
+}
+
+// errObjectNotFound is returned for objects missing from the repository,
+// such as the parents of the oldest commits in a shallow clone
+var errObjectNotFound = errors.New("object not found")
+
+// gitPack is a pack file and its index
+type gitPack struct {
+	path    string   // Path of the .pack file
+	shas    []byte   // Sorted object names, 20 bytes each
+	offsets []uint64 // Offset in the pack of each object, in the order of shas
+}
+
+// readObject returns the type and content of the object named sha,
+// looking in loose objects first and then in pack files
+func (r *gitRepo) readObject(sha string) (string, []byte, error) {
+	if len(sha) != 40 {
+		return "", nil, fmt.Errorf("invalid object name %q", sha)
+	}
+
+	objType, data, err := r.readLooseObject(sha)
+	if err == nil || !os.IsNotExist(err) {
+		return objType, data, err
+	}
+
+	name, err := hex.DecodeString(sha)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid object name %q", sha)
+	}
+	if err := r.loadPacks(); err != nil {
+		return "", nil, err
+	}
+	for _, pack := range r.packs {
+		if offset, ok := pack.find(name); ok {
+			return r.readPackObject(pack, offset)
+		}
+	}
+	return "", nil, fmt.Errorf("%w: %s", errObjectNotFound, sha)
+}
+
+// readLooseObject reads a zlib-compressed object from objects/xx/yyyy
+func (r *gitRepo) readLooseObject(sha string) (string, []byte, error) {
+	f, err := os.Open(filepath.Join(r.commonDir, "objects", sha[:2], sha[2:]))
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	zr, err := zlib.NewReader(f)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// "<type> <size>\x00<content>"
+	header, content, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return "", nil, fmt.Errorf("corrupt object %s", sha)
+	}
+	objType, _, _ := strings.Cut(string(header), " ")
+	return objType, content, nil
+}
+
+// loadPacks reads the indexes of all pack files once
+func (r *gitRepo) loadPacks() error {
+	if r.packs != nil {
+		return nil
+	}
+	r.packs = []*gitPack{}
+
+	indexes, err := filepath.Glob(filepath.Join(r.commonDir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		pack, err := readPackIndex(index)
+		if err != nil {
+			return err
+		}
+		r.packs = append(r.packs, pack)
+	}
+	return nil
+}
+
+// readPackIndex reads a version 2 pack index
+func readPackIndex(path string) (*gitPack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte("\377tOc")) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, fmt.Errorf("unsupported pack index %s", path)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	shaStart := 8 + 256*4
+	offsetStart := shaStart + count*20 + count*4 // Skip the CRC32 table
+	largeStart := offsetStart + count*4
+	if len(data) < largeStart {
+		return nil, fmt.Errorf("truncated pack index %s", path)
+	}
+
+	pack := &gitPack{
+		path:    strings.TrimSuffix(path, ".idx") + ".pack",
+		shas:    data[shaStart : shaStart+count*20],
+		offsets: make([]uint64, count),
+	}
+	for i := range pack.offsets {
+		offset := binary.BigEndian.Uint32(data[offsetStart+i*4:])
+		if offset&0x80000000 == 0 {
+			pack.offsets[i] = uint64(offset)
+			continue
+		}
+		// Offsets past 2GiB are stored in a separate table of 8-byte values
+		large := largeStart + int(offset&0x7fffffff)*8
+		if len(data) < large+8 {
+			return nil, fmt.Errorf("truncated pack index %s", path)
+		}
+		pack.offsets[i] = binary.BigEndian.Uint64(data[large:])
+	}
+	return pack, nil
+}
+
+// find returns the offset of the object named name in the pack
+func (p *gitPack) find(name []byte) (uint64, bool) {
+	count := len(p.offsets)
+	i := sort.Search(count, func(i int) bool {
+		return bytes.Compare(p.shas[i*20:i*20+20], name) >= 0
+	})
+	if i < count && bytes.Equal(p.shas[i*20:i*20+20], name) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// readPackObject reads the object at offset in pack, resolving deltas
+func (r *gitRepo) readPackObject(pack *gitPack, offset uint64) (string, []byte, error) {
+	f, err := os.Open(pack.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	var deltas [][]byte
+	for depth := 0; ; depth++ {
+		if depth > 64 {
+			return "", nil, fmt.Errorf("delta chain too long in %s", pack.path)
+		}
+
+		reader := bufio.NewReader(io.NewSectionReader(f, int64(offset), 1<<62))
+		objType, err := readPackHeader(reader)
+		if err != nil {
+			return "", nil, err
+		}
+
+		var baseSHA string
+		switch objType {
+		case packOfsDelta:
+			distance, err := readOffsetVarint(reader)
+			if err != nil {
+				return "", nil, err
+			}
+			if distance > offset {
+				return "", nil, fmt.Errorf("corrupt delta in %s", pack.path)
+			}
+			offset -= distance
+		case packRefDelta:
+			name := make([]byte, 20)
+			if _, err := io.ReadFull(reader, name); err != nil {
+				return "", nil, err
+			}
+			baseSHA = hex.EncodeToString(name)
+		}
+
+		data, err := inflate(reader)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if objType == packOfsDelta || objType == packRefDelta {
+			deltas = append(deltas, data)
+			if objType == packOfsDelta {
+				continue
+			}
+			// The base of a ref delta may live anywhere, even in another pack
+			baseType, base, err := r.readObject(baseSHA)
+			if err != nil {
+				return "", nil, err
+			}
+			return applyDeltas(baseType, base, deltas)
+		}
+
+		typeName, ok := packTypeNames[objType]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown object type %d in %s", objType, pack.path)
+		}
+		return applyDeltas(typeName, data, deltas)
+	}
+}
+
+// readPackHeader reads the type and size header of a pack entry, returning the type
+func readPackHeader(reader io.ByteReader) (int, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	objType := int(b>>4) & 7
+	// The size is only a hint for the inflated length; skip its remaining bytes
+	for b&0x80 != 0 {
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return objType, nil
+}
+
+// readOffsetVarint reads the base distance of an ofs-delta, which is also
+// the varint encoding used for path prefixes in version 4 indexes
+func readOffsetVarint(reader io.ByteReader) (uint64, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	value := uint64(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, err
+		}
+		value = ((value + 1) << 7) | uint64(b&0x7f)
+	}
+	return value, nil
+}
+
+// inflate decompresses a zlib stream
+func inflate(reader io.Reader) ([]byte, error) {
+	zr, err := zlib.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// applyDeltas applies deltas to base, the innermost (last read) delta first
+func applyDeltas(objType string, base []byte, deltas [][]byte) (string, []byte, error) {
+	for i := len(deltas) - 1; i >= 0; i-- {
+		var err error
+		if base, err = applyDelta(base, deltas[i]); err != nil {
+			return "", nil, err
+		}
+	}
+	return objType, base, nil
+}
+
+// applyDelta builds an object from base and a delta of copy and insert instructions
+func applyDelta(base, delta []byte) ([]byte, error) {
+	reader := bytes.NewReader(delta)
+	if _, err := binary.ReadUvarint(reader); err != nil { // Size of base
+		return nil, err
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, size)
+	for {
+		op, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if op&0x80 == 0 {
+			// Insert the next op bytes
+			if op == 0 {
+				return nil, fmt.Errorf("corrupt delta")
+			}
+			chunk := make([]byte, op)
+			if _, err := io.ReadFull(reader, chunk); err != nil {
+				return nil, err
+			}
+			result = append(result, chunk...)
+			continue
+		}
+
+		// Copy from base; the low bits say which offset and size bytes follow
+		var offset, length uint32
+		for i := uint(0); i < 4; i++ {
+			if op&(1<<i) != 0 {
+				b, err := reader.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				offset |= uint32(b) << (8 * i)
+			}
+		}
+		for i := uint(0); i < 3; i++ {
+			if op&(0x10<<i) != 0 {
+				b, err := reader.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				length |= uint32(b) << (8 * i)
+			}
+		}
+		if length == 0 {
+			length = 0x10000
+		}
+		if uint64(offset)+uint64(length) > uint64(len(base)) {
+			return nil, fmt.Errorf("corrupt delta")
+		}
+		result = append(result, base[offset:offset+length]...)
+	}
+
+	if uint64(len(result)) != size {
+		return nil, fmt.Errorf("corrupt delta")
+	}
+	return result, nil
+}
+
+// gitCommit holds the parts of a commit needed to walk history
+type gitCommit struct {
+	tree    string
+	parents []string
+	time    int64 // Committer timestamp
+}
+
+// readCommit reads and parses the commit named sha
+func (r *gitRepo) readCommit(sha string) (*gitCommit, error) {
+	objType, data, err := r.readObject(sha)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "commit" {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", sha, objType)
+	}
+
+	commit := &gitCommit{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			// End of the header, the message follows
+			break
+		}
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			commit.tree = value
+		case "parent":
+			commit.parents = append(commit.parents, value)
+		case "committer":
+			// "Name <email> <timestamp> <zone>"
+			fields := strings.Fields(value)
+			if len(fields) >= 2 {
+				commit.time, _ = strconv.ParseInt(fields[len(fields)-2], 10, 64)
+			}
+		}
+	}
+	return commit, nil
+}
+
+// gitTreeEntry is a file of a tree, flattened to its full path
+type gitTreeEntry struct {
+	mode uint32
+	sha  string
+}
+
+// readTreeInto adds the files of the tree named sha to files, prefixing their
+// paths. Subdirectories for which skip (if set) returns true are not read;
+// skip gets their path with a trailing slash and their tree.
+func (r *gitRepo) readTreeInto(sha, prefix string, files map[string]gitTreeEntry, skip func(dir, sha string) bool) error {
+	objType, data, err := r.readObject(sha)
+	if err != nil {
+		return err
+	}
+	if objType != "tree" {
+		return fmt.Errorf("object %s is a %s, not a tree", sha, objType)
+	}
+
+	// Entries are "<octal mode> <name>\x00<20-byte sha>"
+	for len(data) > 0 {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < 20 {
+			return fmt.Errorf("corrupt tree %s", sha)
+		}
+		modeText, name, _ := strings.Cut(string(header), " ")
+		mode, err := strconv.ParseUint(modeText, 8, 32)
+		if err != nil {
+			return fmt.Errorf("corrupt tree %s", sha)
+		}
+		entrySHA := hex.EncodeToString(rest[:20])
+		data = rest[20:]
+
+		if mode == 0o40000 {
+			dir := prefix + name + "/"
+			if skip != nil && skip(dir, entrySHA) {
+				continue
+			}
+			if err := r.readTreeInto(entrySHA, dir, files, skip); err != nil {
+				return err
+			}
+			continue
+		}
+		files[prefix+name] = gitTreeEntry{mode: uint32(mode), sha: entrySHA}
+	}
+	return nil
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxAheadBehindCommits bounds the history walked to count ahead/behind commits
+const maxAheadBehindCommits = 10000
+
+// gitStatus counts the changes in a repository
+type gitStatus struct {
+	staged    int // Paths whose index entry differs from HEAD
+	dirty     int // Tracked paths whose worktree file differs from the index
+	untracked int // Files neither tracked nor ignored
+	conflicts int // Paths with unresolved merge conflicts
+}
+
+// status compares HEAD (the commit headSHA, "" on an unborn branch), the index and the worktree
+func (r *gitRepo) status(headSHA string) (gitStatus, error) {
+	var st gitStatus
+
+	entries, cacheTree, err := r.readIndex()
+	if err != nil {
+		return st, err
+	}
+
+	var headFiles map[string]gitTreeEntry
+	var unchanged map[string]bool
+	if headSHA != "" {
+		commit, err := r.readCommit(headSHA)
+		if err != nil {
+			return st, err
+		}
+		if headFiles, unchanged, err = r.headFiles(commit.tree, cacheTree); err != nil {
+			return st, err
+		}
+	}
+
+	tracked := make(map[string]bool, len(entries))
+	conflicted := make(map[string]bool)
+	for _, entry := range entries {
+		tracked[entry.path] = true
+		if entry.stage != 0 {
+			conflicted[entry.path] = true
+			continue
+		}
+
+		if !entry.intentToAdd && !inUnchangedDir(entry.path, unchanged) {
+			if head, ok := headFiles[entry.path]; !ok || head.sha != entry.sha || head.mode != entry.mode {
+				st.staged++
+			}
+		}
+		if !entry.skipWorktree && r.worktreeChanged(entry) {
+			st.dirty++
+		}
+	}
+	st.conflicts = len(conflicted)
+
+	// Files deleted from the index but still in HEAD (unchanged directories
+	// weren't read, and have none)
+	for path := range headFiles {
+		if !tracked[path] {
+			st.staged++
+		}
+	}
+
+	st.untracked, err = r.countUntracked(tracked)
+	return st, err
+}
+
+// headFiles returns the files of the HEAD tree named sha, skipping the
+// directories that the index's cache-tree records as the very same tree: their
+// index entries can't differ from HEAD, so they are returned in unchanged
+// (as "dir/", "" for the root) instead of being read. With an up-to-date
+// cache-tree, as after a commit or checkout, only the changed directories are read.
+func (r *gitRepo) headFiles(sha string, cacheTree *gitCacheTree) (map[string]gitTreeEntry, map[string]bool, error) {
+	files := make(map[string]gitTreeEntry)
+	unchanged := make(map[string]bool)
+	skip := func(dir, sha string) bool {
+		if node := cacheTree.lookup(dir); node.valid() && node.sha == sha {
+			unchanged[dir] = true
+			return true
+		}
+		return false
+	}
+
+	if skip("", sha) {
+		return files, unchanged, nil
+	}
+	return files, unchanged, r.readTreeInto(sha, "", files, skip)
+}
+
+// inUnchangedDir reports whether path is below one of the unchanged directories of headFiles
+func inUnchangedDir(path string, unchanged map[string]bool) bool {
+	if len(unchanged) == 0 {
+		return false
+	}
+	if unchanged[""] {
+		return true
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && unchanged[path[:i+1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// worktreeChanged reports whether the worktree file of an index entry differs from it.
+// Files whose size and mtime match the index are assumed unchanged, like git does;
+// others are hashed.
+func (r *gitRepo) worktreeChanged(entry gitIndexEntry) bool {
+	if isGitlink(entry.mode) {
+		// Changes inside submodules are not tracked
+		return false
+	}
+	if entry.intentToAdd {
+		return true
+	}
+
+	path := filepath.Join(r.workTree, filepath.FromSlash(entry.path))
+	info, err := os.Lstat(path)
+	if err != nil || info.IsDir() {
+		return true
+	}
+	if worktreeMode(info) != entry.mode || uint32(info.Size()) != entry.size {
+		return true
+	}
+
+	mtime := info.ModTime()
+	if uint32(mtime.Unix()) == entry.mtimeSec && uint32(mtime.Nanosecond()) == entry.mtimeNsec {
+		return false
+	}
+
+	content, err := readFileForHash(path, info)
+	if err != nil {
+		return true
+	}
+	return hashBlob(content) != entry.sha
+}
+
+// worktreeMode returns the index mode of a worktree file
+func worktreeMode(info os.FileInfo) uint32 {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return 0o120000
+	case info.Mode()&0o111 != 0:
+		return 0o100755
+	default:
+		return 0o100644
+	}
+}
+
+// hashObject returns the object name of content stored as an object of objType
+func hashObject(objType string, content []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", objType, len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// countUntracked counts the files in the worktree that are neither tracked
+// nor ignored. Ignored directories are not descended into, and a nested
+// repository counts as a single untracked entry.
+func (r *gitRepo) countUntracked(tracked map[string]bool) (int, error) {
+	// Lowest precedence first: core.excludesFile, info/exclude, .gitignore
+	ignore := &gitIgnore{}
+	if excludesFile := r.excludesFile(); excludesFile != "" {
+		ignore.addFile(excludesFile, "")
+	}
+	ignore.addFile(filepath.Join(r.commonDir, "info", "exclude"), "")
+
+	count := 0
+	err := filepath.WalkDir(r.workTree, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are skipped rather than failing the status
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(r.workTree, fullPath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == "." {
+				ignore.addFile(filepath.Join(fullPath, ".gitignore"), "")
+				return nil
+			}
+			if d.Name() == ".git" || ignore.ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			if _, err := os.Lstat(filepath.Join(fullPath, ".git")); err == nil {
+				// Nested repository or submodule
+				if !tracked[rel] {
+					count++
+				}
+				return filepath.SkipDir
+			}
+			ignore.addFile(filepath.Join(fullPath, ".gitignore"), rel)
+			return nil
+		}
+
+		// .git is a file in linked worktrees and submodules
+		if d.Name() != ".git" && !tracked[rel] && !ignore.ignored(rel, false) {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// excludesFile returns core.excludesFile from the repository or global
+// config, defaulting to $XDG_CONFIG_HOME/git/ignore like git
+func (r *gitRepo) excludesFile() string {
+	home, _ := os.UserHomeDir()
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	// Later files take precedence, as in git
+	var configs []string
+	if configHome != "" {
+		configs = append(configs, filepath.Join(configHome, "git", "config"))
+	}
+	if home != "" {
+		configs = append(configs, filepath.Join(home, ".gitconfig"))
+	}
+	configs = append(configs, filepath.Join(r.commonDir, "config"))
+
+	excludesFile := ""
+	for _, config := range configs {
+		if value, ok := readGitConfig(config)["core.excludesfile"]; ok {
+			excludesFile = value
+		}
+	}
+	if excludesFile == "" {
+		if configHome == "" {
+			return ""
+		}
+		return filepath.Join(configHome, "git", "ignore")
+	}
+	if rest, ok := strings.CutPrefix(excludesFile, "~/"); ok && home != "" {
+		return filepath.Join(home, rest)
+	}
+	return excludesFile
+}
+
+// gitIgnore matches paths against .gitignore rules
+type gitIgnore struct {
+	rules []ignoreRule
+}
+
+// ignoreRule is one pattern of a .gitignore file
+type ignoreRule struct {
+	pattern  *regexp.Regexp
+	base     string // Directory of the .gitignore relative to the worktree ("" at the top)
+	negate   bool   // !pattern re-includes a path
+	dirOnly  bool   // pattern/ only matches directories
+	basename bool   // A pattern without a slash matches the name at any depth
+}
+
+// addFile adds the rules of the ignore file at path, which apply below base
+func (g *gitIgnore) addFile(path, base string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		rule := ignoreRule{base: base}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		rule.basename = !strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		pattern, err := globToRegexp(line)
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		g.rules = append(g.rules, rule)
+	}
+}
+
+// ignored reports whether the slash-separated path rel is ignored; the last matching rule wins
+func (g *gitIgnore) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		target := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			target = rel[len(rule.base)+1:]
+		}
+		if rule.basename {
+			target = path.Base(target)
+		}
+
+		if rule.pattern.MatchString(target) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// globToRegexp converts a gitignore glob to an anchored regular expression
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch rest := glob[i:]; {
+		case strings.HasPrefix(rest, "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case rest == "/**":
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(rest, "**"):
+			b.WriteString(".*")
+			i++
+		case rest[0] == '*':
+			b.WriteString("[^/]*")
+		case rest[0] == '?':
+			b.WriteString("[^/]")
+		case rest[0] == '[':
+			end := strings.IndexByte(rest[1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := rest[1 : end+1]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case rest[0] == '\\' && len(rest) > 1:
+			b.WriteString(regexp.QuoteMeta(rest[1:2]))
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(rest[:1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// upstream returns the short name and the ref of the branch's upstream, as set
+// by branch.<name>.remote and branch.<name>.merge in the repository config
+func (r *gitRepo) upstream(branch string) (name string, ref string) {
+	config := readGitConfig(filepath.Join(r.commonDir, "config"))
+	remote := config["branch."+branch+".remote"]
+	merge := config["branch."+branch+".merge"]
+	if remote == "" || merge == "" {
+		return "", ""
+	}
+
+	mergeBranch := strings.TrimPrefix(merge, "refs/heads/")
+	if remote == "." {
+		// Tracks a local branch
+		return mergeBranch, merge
+	}
+	return remote + "/" + mergeBranch, "refs/remotes/" + remote + "/" + mergeBranch
+}
+
+// readGitConfig reads a git config file into a map keyed by
+// "section.subsection.key" (section and key lowercased). Includes are not followed.
+func readGitConfig(path string) map[string]string {
+	values := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			// [section] or [section "subsection"]
+			header := strings.TrimSpace(line[1 : len(line)-1])
+			name, sub, ok := strings.Cut(header, " ")
+			section = strings.ToLower(name)
+			if ok {
+				if unquoted, err := strconv.Unquote(strings.TrimSpace(sub)); err == nil {
+					sub = unquoted
+				}
+				section += "." + sub
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			// A key without value means true
+			value = "true"
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		values[section+"."+strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return values
+}
+
+// aheadBehind counts the commits reachable from local but not upstream (ahead)
+// and the other way round (behind), like git rev-list --left-right --count.
+// History is walked newest first and stops once only shared commits are left.
+func (r *gitRepo) aheadBehind(local, upstream string) (int, int, error) {
+	if local == upstream {
+		return 0, 0, nil
+	}
+
+	const (
+		fromLocal    = 1
+		fromUpstream = 2
+		fromBoth     = fromLocal | fromUpstream
+	)
+
+	flags := map[string]int{}
+	commits := map[string]*gitCommit{}
+	queue := &commitQueue{}
+	// push marks a commit and queues it again whenever it gains a mark,
+	// so the mark reaches its ancestors
+	push := func(sha string, flag int) error {
+		if flags[sha]|flag == flags[sha] {
+			return nil
+		}
+		commit, ok := commits[sha]
+		if !ok {
+			var err error
+			if commit, err = r.readCommit(sha); err != nil {
+				return err
+			}
+			commits[sha] = commit
+		}
+		flags[sha] |= flag
+		heap.Push(queue, queuedCommit{sha: sha, commit: commit})
+		return nil
+	}
+
+	if err := push(local, fromLocal); err != nil {
+		return 0, 0, err
+	}
+	if err := push(upstream, fromUpstream); err != nil {
+		return 0, 0, err
+	}
+
+	walked := 0
+	for queue.Len() > 0 && !queue.allMarked(flags, fromBoth) {
+		walked++
+		if walked > maxAheadBehindCommits {
+			return 0, 0, fmt.Errorf("history too long to count ahead/behind")
+		}
+
+		next := heap.Pop(queue).(queuedCommit)
+		for _, parent := range next.commit.parents {
+			// Missing parents (shallow clones) end the history
+			if err := push(parent, flags[next.sha]); err != nil && !errors.Is(err, errObjectNotFound) {
+				return 0, 0, err
+			}
+		}
+	}
+
+	ahead, behind := 0, 0
+	for _, flag := range flags {
+		switch flag {
+		case fromLocal:
+			ahead++
+		case fromUpstream:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// queuedCommit is a commit waiting in the history walk
+type queuedCommit struct {
+	sha    string
+	commit *gitCommit
+}
+
+// commitQueue orders commits newest first
+type commitQueue []queuedCommit
+
+func (q commitQueue) Len() int            { return len(q) }
+func (q commitQueue) Less(i, j int) bool  { return q[i].commit.time > q[j].commit.time }
+func (q commitQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x interface{}) { *q = append(*q, x.(queuedCommit)) }
+func (q *commitQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// allMarked reports whether every queued commit carries all of mask
+func (q commitQueue) allMarked(flags map[string]int, mask int) bool {
+	for _, item := range q {
+		if flags[item.sha]&mask != mask {
+			return false
+		}
+	}
+	return true
+}
+
+// state returns the operation in progress in the worktree ("rebase", "am",
+// "merge", "cherry-pick", "revert", "bisect" or "") and, during a rebase,
+// the branch being rebased
+func (r *gitRepo) state() (string, string) {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(r.gitDir, name))
+		return err == nil
+	}
+	readBranch := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(r.gitDir, name))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimPrefix(strings.TrimSpace(string(data)), "refs/heads/")
+	}
+
+	switch {
+	case exists("rebase-merge"):
+		return "rebase", readBranch("rebase-merge/head-name")
+	case exists("rebase-apply/applying"):
+		return "am", ""
+	case exists("rebase-apply"):
+		return "rebase", readBranch("rebase-apply/head-name")
+	case exists("MERGE_HEAD"):
+		return "merge", ""
+	case exists("CHERRY_PICK_HEAD"):
+		return "cherry-pick", ""
+	case exists("REVERT_HEAD"):
+		return "revert", ""
+	case exists("BISECT_LOG"):
+		return "bisect", ""
+	}
+	return "", ""
+}
+
+// computedFieldPattern matches a reference to .ccstatusline and the field and
+// subfield it accesses, if any
+var computedFieldPattern = regexp.MustCompile(`\.` + regexp.QuoteMeta(computedFieldsKey) + `\b(?:\.(\w+)(?:\.(\w+))?)?`)
+
+// gitStatusFields are the fields of .ccstatusline.git that need gitRepo.status
+var gitStatusFields = map[string]bool{"staged": true, "dirty": true, "untracked": true, "conflicts": true, "clean": true}
+
+// gitFieldsUsed reports whether text may refer to .ccstatusline.git at all,
+// and whether it needs the change counts. A reference to the whole object,
+// of .ccstatusline (e.g. {.ccstatusline | .git.dirty}) or of .ccstatusline.git
+// (e.g. {.ccstatusline.git | .dirty}), may use any field, so it needs both.
+func gitFieldsUsed(text string) (used bool, withStatus bool) {
+	for _, match := range computedFieldPattern.FindAllStringSubmatch(text, -1) {
+		field, subfield := match[1], match[2]
+		if field != "" && field != "git" {
+			continue
+		}
+		used = true
+		if field == "" || subfield == "" || gitStatusFields[subfield] {
+			withStatus = true
+		}
+	}
+	return used, withStatus
+}
+
+// gitFields returns the template fields describing the repository containing
+// dir, exposed as .ccstatusline.git. The change counts need the index and a
+// worktree scan, so they are only computed when withStatus is set. Fields that
+// could not be read are left out and the first error is returned with the rest.
+func gitFields(dir string, withStatus bool) (map[string]interface{}, error) {
+	repo, err := findGitRepo(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, sha, err := repo.head()
+	if err != nil {
+		return nil, err
+	}
+
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	state, rebasing := repo.state()
+	if branch == "" && rebasing != "" {
+		branch = rebasing
+	}
+
+	fields := map[string]interface{}{
+		"root":      repo.workTree,
+		"branch":    branch,
+		"sha":       sha,
+		"short_sha": sha[:min(len(sha), 7)],
+		"detached":  ref == "",
+		"state":     state,
+		"worktree":  repo.gitDir != repo.commonDir,
+		"submodule": strings.Contains(filepath.ToSlash(repo.commonDir), "/modules/"),
+	}
+
+	var firstErr error
+	if upstreamName, upstreamRef := repo.upstream(branch); upstreamRef != "" && sha != "" {
+		fields["upstream"] = upstreamName
+		if upstreamSHA, err := repo.resolveRef(upstreamRef); err == nil {
+			if ahead, behind, err := repo.aheadBehind(sha, upstreamSHA); err == nil {
+				fields["ahead"] = ahead
+				fields["behind"] = behind
+			} else {
+				firstErr = err
+			}
+		}
+	}
+
+	if withStatus {
+		if st, err := repo.status(sha); err == nil {
+			fields["staged"] = st.staged
+			fields["dirty"] = st.dirty
+			fields["untracked"] = st.untracked
+			fields["conflicts"] = st.conflicts
+			fields["clean"] = st == gitStatus{}
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return fields, firstErr
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runGit runs git in dir with a fixed identity and no user config, skipping the test without git
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL=/dev/null",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitFile writes a file and commits it
+func commitFile(t *testing.T, dir, name, content, message string) {
+	t.Helper()
+	writeFiles(t, dir, map[string]string{name: content})
+	runGit(t, dir, "add", name)
+	runGit(t, dir, "commit", "-q", "-m", message)
+}
+
+func TestGitFields(t *testing.T) {
+	root := t.TempDir()
+	upstream := filepath.Join(root, "upstream")
+	local := filepath.Join(root, "local")
+
+	if err := os.MkdirAll(upstream, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, upstream, "init", "-q", "-b", "main")
+	// Many versions of the same file, so packing stores deltas
+	for i := 0; i < 20; i++ {
+		commitFile(t, upstream, "src/main.go", strings.Repeat(fmt.Sprintf("line %d\n", i), 50+i), fmt.Sprintf("commit %d", i))
+	}
+	commitFile(t, upstream, ".gitignore", "*.log\nbuild/\n!keep.log\n/root-only\n", "ignore")
+	commitFile(t, upstream, "README.md", "readme\n", "readme")
+
+	runGit(t, root, "clone", "-q", upstream, local)
+	commitFile(t, upstream, "upstream.txt", "new\n", "upstream change")
+	commitFile(t, local, "local1.txt", "1\n", "local 1")
+	commitFile(t, local, "local2.txt", "2\n", "local 2")
+	runGit(t, local, "fetch", "-q")
+	runGit(t, local, "gc", "-q", "--aggressive")
+
+	// Worktree changes
+	writeFiles(t, local, map[string]string{
+		"src/main.go":          "changed\n", // dirty
+		"staged.txt":           "staged\n",  // staged (added)
+		"untracked.txt":        "",          // untracked
+		"src/sub/new.go":       "",          // untracked
+		"debug.log":            "",          // ignored
+		"keep.log":             "",          // re-included by !keep.log
+		"build/out.bin":        "",          // ignored directory
+		"root-only":            "",          // ignored at the top only
+		"src/root-only":        "",          // untracked
+		"src/deep/x/trace.log": "",          // ignored at any depth
+	})
+	runGit(t, local, "add", "staged.txt")
+	runGit(t, local, "rm", "-q", "README.md") // staged (deleted)
+
+	expected := map[string]interface{}{
+		"branch":    "main",
+		"detached":  false,
+		"upstream":  "origin/main",
+		"ahead":     2,
+		"behind":    1,
+		"staged":    2,
+		"dirty":     1,
+		"untracked": 4,
+		"conflicts": 0,
+		"clean":     false,
+		"state":     "",
+		"worktree":  false,
+		"submodule": false,
+	}
+
+	check := func(t *testing.T, dir string, expected map[string]interface{}) map[string]interface{} {
+		t.Helper()
+		fields, err := gitFields(dir, true)
+		if err != nil {
+			t.Fatalf("gitFields() error = %v", err)
+		}
+		for key, want := range expected {
+			if got := fields[key]; got != want {
+				t.Errorf("%s = %v, want %v", key, got, want)
+			}
+		}
+		return fields
+	}
+
+	t.Run("status", func(t *testing.T) {
+		fields := check(t, filepath.Join(local, "src"), expected)
+		if sha := runGit(t, local, "rev-parse", "HEAD"); fields["sha"] != sha || fields["short_sha"] != sha[:7] {
+			t.Errorf("sha = %v, short_sha = %v, want %s", fields["sha"], fields["short_sha"], sha)
+		}
+
+		// Cross-check the counts with git itself
+		if counts := runGit(t, local, "rev-list", "--left-right", "--count", "HEAD...@{upstream}"); counts != "2\t1" {
+			t.Errorf("git rev-list = %q", counts)
+		}
+		if untracked := runGit(t, local, "ls-files", "--others", "--exclude-standard"); len(strings.Fields(untracked)) != 4 {
+			t.Errorf("git ls-files --others = %q", untracked)
+		}
+	})
+
+	t.Run("index version 4", func(t *testing.T) {
+		runGit(t, local, "update-index", "--index-version", "4")
+		check(t, local, expected)
+	})
+
+	t.Run("merge in progress", func(t *testing.T) {
+		sha := runGit(t, local, "rev-parse", "HEAD")
+		writeFiles(t, local, map[string]string{".git/MERGE_HEAD": sha + "\n"})
+		defer os.Remove(filepath.Join(local, ".git", "MERGE_HEAD"))
+		check(t, local, map[string]interface{}{"state": "merge"})
+	})
+
+	t.Run("linked worktree", func(t *testing.T) {
+		wt := filepath.Join(root, "wt")
+		runGit(t, local, "worktree", "add", "-q", "-b", "feature", wt)
+		check(t, wt, map[string]interface{}{
+			"branch":    "feature",
+			"worktree":  true,
+			"clean":     true,
+			"untracked": 0,
+		})
+	})
+
+	t.Run("detached", func(t *testing.T) {
+		detached := filepath.Join(root, "detached")
+		runGit(t, local, "worktree", "add", "-q", "--detach", detached, "HEAD~1")
+		fields := check(t, detached, map[string]interface{}{"branch": "", "detached": true})
+		if _, ok := fields["upstream"]; ok {
+			t.Errorf("upstream = %v, want none when detached", fields["upstream"])
+		}
+	})
+
+	t.Run("submodule", func(t *testing.T) {
+		runGit(t, local, "-c", "protocol.file.allow=always", "submodule", "add", "-q", upstream, "vendor/up")
+		sub := filepath.Join(local, "vendor", "up")
+		check(t, sub, map[string]interface{}{"branch": "main", "submodule": true, "clean": true})
+		// The submodule itself is a staged change of the parent
+		check(t, local, map[string]interface{}{"staged": 4, "untracked": 4})
+	})
+}
+
+func TestGitStatusUsesCacheTree(t *testing.T) {
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	writeFiles(t, dir, map[string]string{"a/x.txt": "x\n", "b/y.txt": "y\n", "b/c/z.txt": "z\n", "top.txt": "t\n"})
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-q", "-m", "initial")
+
+	writeFiles(t, dir, map[string]string{"a/x.txt": "changed\n"})
+	runGit(t, dir, "add", "a/x.txt")
+	runGit(t, dir, "rm", "-q", "top.txt")
+
+	repo, err := findGitRepo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cacheTree, err := repo.readIndex()
+	if err != nil {
+		t.Fatalf("readIndex() error = %v", err)
+	}
+	if cacheTree == nil || cacheTree.valid() || !cacheTree.lookup("b/").valid() || cacheTree.lookup("a/").valid() {
+		t.Fatalf("cache-tree = %+v, want only b/ valid", cacheTree)
+	}
+
+	_, sha, err := repo.head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.readCommit(sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, unchanged, err := repo.headFiles(commit.tree, cacheTree)
+	if err != nil {
+		t.Fatalf("headFiles() error = %v", err)
+	}
+	if _, ok := files["b/y.txt"]; ok || !unchanged["b/"] {
+		t.Errorf("b/ was read: files = %v, unchanged = %v", files, unchanged)
+	}
+	if _, ok := files["a/x.txt"]; !ok {
+		t.Errorf("a/ was not read: files = %v", files)
+	}
+
+	// The counts match those without a cache-tree
+	st, err := repo.status(sha)
+	if err != nil {
+		t.Fatalf("status() error = %v", err)
+	}
+	if st.staged != 2 || st.dirty != 0 || st.untracked != 0 {
+		t.Errorf("status() = %+v, want 2 staged", st)
+	}
+	if staged := runGit(t, dir, "diff", "--cached", "--name-only"); len(strings.Fields(staged)) != st.staged {
+		t.Errorf("git diff --cached = %q", staged)
+	}
+}
+
+func TestGitStatusGlobalExcludes(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	writeFiles(t, home, map[string]string{
+		".config/git/ignore": "*.log\n",
+		"custom-ignore":      "*.tmp\n",
+	})
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q", "-b", "main")
+	commitFile(t, dir, "README.md", "readme\n", "initial")
+	writeFiles(t, dir, map[string]string{"x.log": "", "x.tmp": "", "new.txt": ""})
+
+	untracked := func() int {
+		t.Helper()
+		repo, err := findGitRepo(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, sha, err := repo.head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := repo.status(sha)
+		if err != nil {
+			t.Fatalf("status() error = %v", err)
+		}
+		return st.untracked
+	}
+
+	// $XDG_CONFIG_HOME/git/ignore by default
+	if got := untracked(); got != 2 {
+		t.Errorf("untracked = %d, want 2 (x.tmp and new.txt)", got)
+	}
+
+	// core.excludesFile replaces the default
+	runGit(t, dir, "config", "core.excludesFile", "~/custom-ignore")
+	if got := untracked(); got != 2 {
+		t.Errorf("untracked with core.excludesFile = %d, want 2 (x.log and new.txt)", got)
+	}
+	if output := runGit(t, dir, "ls-files", "--others", "--exclude-standard"); output != "new.txt\nx.log" {
+		t.Errorf("git ls-files --others = %q", output)
+	}
+}
+
+func TestGitFieldsUsed(t *testing.T) {
+	tests := []struct {
+		text       string
+		used       bool
+		withStatus bool
+	}{
+		{text: "{.ccstatusline.git.branch}", used: true},
+		{text: "{.ccstatusline.git.dirty}", used: true, withStatus: true},
+		{text: `.ccstatusline.git.clean | not`, used: true, withStatus: true},
+		{text: "{.ccstatusline.git | .staged}", used: true, withStatus: true},
+		// Words that happen to be field names don't need the status
+		{text: "make clean; echo dirty: {.ccstatusline.git.branch}", used: true},
+		{text: "make clean && echo staged untracked", used: false},
+		{text: "{.ccstatusline.github}", used: false},
+		{text: "{.ccstatusline.cost.total}", used: false},
+		// Other ways of reaching the object may use any field
+		{text: "{.ccstatusline | .git.branch}", used: true, withStatus: true},
+		{text: `{.ccstatusline["git"].branch}`, used: true, withStatus: true},
+		{text: `{.ccstatusline.git["dirty"]}`, used: true, withStatus: true},
+	}
+
+	for _, tt := range tests {
+		used, withStatus := gitFieldsUsed(tt.text)
+		if used != tt.used || withStatus != tt.withStatus {
+			t.Errorf("gitFieldsUsed(%q) = %v, %v, want %v, %v", tt.text, used, withStatus, tt.used, tt.withStatus)
+		}
+	}
+}
+
+func TestGitFieldsNotARepository(t *testing.T) {
+	if fields, err := gitFields(t.TempDir(), true); err == nil || fields != nil {
+		t.Errorf("gitFields() = %v, %v, want an error", fields, err)
+	}
+}
+
+func TestGitIgnore(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".gitignore":     "*.tmp\n/dist\nlogs/\n**/cache/**\ndoc/*.pdf\n!important.tmp\n\\#hash\n",
+		"sub/.gitignore": "local-only\n",
+	})
+	ignore := &gitIgnore{}
+	ignore.addFile(filepath.Join(root, ".gitignore"), "")
+	ignore.addFile(filepath.Join(root, "sub", ".gitignore"), "sub")
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{path: "a.tmp", ignored: true},
+		{path: "deep/b.tmp", ignored: true},
+		{path: "important.tmp", ignored: false},
+		{path: "dist", isDir: true, ignored: true},
+		{path: "sub/dist", isDir: true, ignored: false},
+		{path: "logs", isDir: true, ignored: true},
+		{path: "logs", isDir: false, ignored: false},
+		{path: "a/cache/b/c", ignored: true},
+		{path: "doc/x.pdf", ignored: true},
+		{path: "doc/sub/x.pdf", ignored: false},
+		{path: "#hash", ignored: true},
+		{path: "sub/local-only", ignored: true},
+		{path: "local-only", ignored: false},
+	}
+
+	for _, tt := range tests {
+		if got := ignore.ignored(tt.path, tt.isDir); got != tt.ignored {
+			t.Errorf("ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+}
+
+func TestProcessorGitFields(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".git/HEAD":               "ref: refs/heads/topic\n",
+		".git/refs/heads/topic":   "1111111111111111111111111111111111111111\n",
+		".git/rebase-merge/.keep": "",
+	})
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+		read     bool
+	}{
+		{name: "branch and state", template: "{.ccstatusline.git.branch} {.ccstatusline.git.state}", expected: "topic rebase", read: true},
+		{name: "whole object", template: "{.ccstatusline | has(\"git\")}", expected: "true", read: true},
+		// Without a reference to .ccstatusline.git the repository isn't read
+		{name: "not referenced", template: "{.ccstatusline.tokens | type}", expected: "null", read: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Actions: []Action{{Name: "git", Template: tt.template}}}
+			processor := NewProcessor(map[string]interface{}{"cwd": root})
+			processor.cache = NewCache(t.TempDir())
+
+			result, err := processor.Process(config)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+			if _, read := processor.inputData[computedFieldsKey].(map[string]interface{})["git"]; read != tt.read {
+				t.Errorf("repository read = %v, want %v", read, tt.read)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates files (and their parent directories) under root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveGitHead(t *testing.T) {
+	const mainSHA = "1111111111111111111111111111111111111111"
+	const packedSHA = "2222222222222222222222222222222222222222"
+	const detachedSHA = "3333333333333333333333333333333333333333"
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		// Regular repository on a loose branch
+		"repo/.git/HEAD":            "ref: refs/heads/main\n",
+		"repo/.git/refs/heads/main": mainSHA + "\n",
+		"repo/src/pkg/.keep":        "",
+
+		// Branch only present in packed-refs
+		"packed/.git/HEAD":        "ref: refs/heads/feature\n",
+		"packed/.git/packed-refs": "# pack-refs with: peeled fully-peeled sorted\n" + packedSHA + " refs/heads/feature\n",
+
+		// Linked worktree with detached HEAD
+		"repo/.git/worktrees/wt/HEAD":      detachedSHA + "\n",
+		"repo/.git/worktrees/wt/commondir": "../..\n",
+		"wt/.git":                          "gitdir: ../repo/.git/worktrees/wt\n",
+
+		// Unborn branch
+		"empty/.git/HEAD": "ref: refs/heads/main\n",
+	})
+
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{name: "loose ref", dir: "repo", want: mainSHA},
+		{name: "from subdirectory", dir: "repo/src/pkg", want: mainSHA},
+		{name: "packed ref", dir: "packed", want: packedSHA},
+		{name: "worktree detached", dir: "wt", want: detachedSHA},
+		{name: "unborn branch", dir: "empty", want: ""},
+		{name: "not a repository", dir: ".", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveGitHead(filepath.Join(root, tt.dir))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveGitHead() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveGitHead() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// tryLockFile always succeeds on platforms without flock; concurrent renders
+// may then run the same command, but atomic writes keep the cache consistent.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlockFile is a no-op on platforms without flock
+func unlockFile(f *os.File) {}
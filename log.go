@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	logFileName = "log.jsonl"
+	logMaxSize  = 1 << 20 // Bytes after which the log is rotated to log.jsonl.1
+	logLevelEnv = "CCSTATUSLINE_LOG"
+)
+
+// logLevel is the minimum severity of the entries written to the log
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota // Cache hits, misses and stores of every action
+	logLevelInfo                  // Background refreshes
+	logLevelWarn                  // Problems that don't change the output, e.g. a cache write failing (default)
+	logLevelError                 // Failed actions and configs that can't be loaded
+	logLevelOff
+)
+
+const defaultLogLevel = logLevelWarn
+
+var logLevelNames = map[string]logLevel{
+	"debug": logLevelDebug,
+	"info":  logLevelInfo,
+	"warn":  logLevelWarn,
+	"error": logLevelError,
+	"off":   logLevelOff,
+}
+
+func (l logLevel) String() string {
+	for name, level := range logLevelNames {
+		if level == l {
+			return name
+		}
+	}
+	return fmt.Sprintf("logLevel(%d)", int(l))
+}
+
+// parseLogLevel parses a log_level value; empty means the default
+func parseLogLevel(name string) (logLevel, error) {
+	if name == "" {
+		return defaultLogLevel, nil
+	}
+	level, ok := logLevelNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown log_level %q (want debug, info, warn, error or off)", name)
+	}
+	return level, nil
+}
+
+// resolveLogLevel returns the level set by CCSTATUSLINE_LOG, which wins so a
+// single run can be debugged without editing the config, or else configured.
+// An invalid CCSTATUSLINE_LOG is ignored.
+func resolveLogLevel(configured string) logLevel {
+	if env := os.Getenv(logLevelEnv); env != "" {
+		if level, err := parseLogLevel(env); err == nil {
+			return level
+		}
+	}
+	if level, err := parseLogLevel(configured); err == nil {
+		return level
+	}
+	return defaultLogLevel
+}
+
+// Logger appends JSON lines to a log file under the XDG state directory.
+// Claude Code discards the statusline's stderr, so this is where failures
+// can be looked up afterwards. A nil *Logger discards everything.
+type Logger struct {
+	path    string
+	maxSize int64
+	level   logLevel
+	mu      sync.Mutex
+}
+
+// NewLogger creates a logger writing to dir/log.jsonl at the level set by
+// CCSTATUSLINE_LOG or the default
+func NewLogger(dir string) *Logger {
+	return &Logger{path: filepath.Join(dir, logFileName), maxSize: logMaxSize, level: resolveLogLevel("")}
+}
+
+// SetLevel changes the minimum level of the entries written
+func (l *Logger) SetLevel(level logLevel) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// NewDefaultLogger creates a logger following the XDG Base Directory specification
+func NewDefaultLogger() *Logger {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			stateDir = filepath.Join(os.TempDir(), "ccstatusline-state")
+		} else {
+			stateDir = filepath.Join(homeDir, ".local", "state")
+		}
+	}
+	return NewLogger(filepath.Join(stateDir, "ccstatusline"))
+}
+
+// Debug logs msg with fields at the debug level
+func (l *Logger) Debug(msg string, fields map[string]interface{}) {
+	l.log(logLevelDebug, msg, fields)
+}
+
+// Info logs msg with fields at the info level
+func (l *Logger) Info(msg string, fields map[string]interface{}) {
+	l.log(logLevelInfo, msg, fields)
+}
+
+// Warn logs msg with fields at the warn level
+func (l *Logger) Warn(msg string, fields map[string]interface{}) {
+	l.log(logLevelWarn, msg, fields)
+}
+
+// Error logs msg with fields at the error level
+func (l *Logger) Error(msg string, fields map[string]interface{}) {
+	l.log(logLevelError, msg, fields)
+}
+
+// log appends one entry if level is enabled. Logging is best effort: errors
+// are ignored so that a read-only state directory never breaks the statusline.
+func (l *Logger) log(level logLevel, msg string, fields map[string]interface{}) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	enabled := level >= l.level && level < logLevelOff
+	l.mu.Unlock()
+	if !enabled {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(fields)+3)
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return
+	}
+	l.rotate(int64(len(line)))
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	// A single write with O_APPEND keeps lines of concurrent processes intact
+	f.Write(line)
+}
+
+// rotate moves the log to log.jsonl.1, replacing the previous one, when
+// appending n more bytes would make it exceed maxSize
+func (l *Logger) rotate(n int64) {
+	info, err := os.Stat(l.path)
+	if err != nil || info.Size() == 0 || info.Size()+n <= l.maxSize {
+		return
+	}
+	os.Rename(l.path, l.path+".1")
+}
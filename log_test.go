@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRotation(t *testing.T) {
+	dir := t.TempDir()
+	logger := NewLogger(filepath.Join(dir, "state"))
+	logger.maxSize = 200
+
+	for i := 0; i < 5; i++ {
+		logger.Error("action failed", map[string]interface{}{"action": "a", "i": i})
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, "state", logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := os.ReadFile(filepath.Join(dir, "state", logFileName+".1"))
+	if err != nil {
+		t.Fatalf("log was not rotated: %v", err)
+	}
+	if len(current) > 200 || len(rotated) > 200 {
+		t.Errorf("log sizes %d and %d exceed the limit", len(current), len(rotated))
+	}
+
+	// The newest entry is last in the current file
+	lines := strings.Split(strings.TrimSpace(string(current)), "\n")
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v", err)
+	}
+	if entry["i"] != float64(4) || entry["level"] != "error" || entry["msg"] != "action failed" || entry["time"] == nil {
+		t.Errorf("last entry = %v", entry)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var logger *Logger
+	logger.Error("ignored", nil)
+}
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		env        string
+		expected   []string
+	}{
+		{name: "default is warn", expected: []string{"warn", "error"}},
+		{name: "configured", configured: "debug", expected: []string{"debug", "info", "warn", "error"}},
+		{name: "env overrides config", configured: "debug", env: "error", expected: []string{"error"}},
+		{name: "off", configured: "off", expected: nil},
+		{name: "invalid env is ignored", configured: "info", env: "verbose", expected: []string{"info", "warn", "error"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(logLevelEnv, tt.env)
+			dir := t.TempDir()
+			logger := NewLogger(dir)
+			logger.SetLevel(resolveLogLevel(tt.configured))
+
+			logger.Debug("d", nil)
+			logger.Info("i", nil)
+			logger.Warn("w", nil)
+			logger.Error("e", nil)
+
+			data, err := os.ReadFile(filepath.Join(dir, logFileName))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			var levels []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				if line == "" {
+					continue
+				}
+				var entry map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("log line is not JSON: %v", err)
+				}
+				levels = append(levels, entry["level"].(string))
+			}
+			if strings.Join(levels, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("logged levels = %v, want %v", levels, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessorLogsCacheEvents(t *testing.T) {
+	t.Setenv(logLevelEnv, "")
+	logDir := t.TempDir()
+	config := &Config{
+		Actions:  []Action{{Name: "date", Command: "echo now", CacheTTL: 60}},
+		LogLevel: "debug",
+	}
+
+	for i := 0; i < 2; i++ {
+		processor := NewProcessor(map[string]interface{}{"cwd": "/work"})
+		processor.cache = NewCache(filepath.Join(logDir, "cache"))
+		processor.logger = NewLogger(logDir)
+		if _, err := processor.Process(config); err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(logDir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %v", err)
+		}
+		if entry["action"] != "date" || entry["level"] != "debug" {
+			t.Errorf("entry = %v", entry)
+		}
+		messages = append(messages, entry["msg"].(string))
+	}
+	if got := strings.Join(messages, ","); got != "cache miss,cache stored,cache hit" {
+		t.Errorf("logged %s", got)
+	}
+}
@@ -6,40 +6,123 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cache":
+			if err := runCacheCommand(os.Args[2:], NewDefaultCache(), os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "preview":
+			if err := runPreviewCommand(os.Args[2:], defaultFixturesDir(), os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "trace":
+			if err := runTraceCommand(os.Args[2:], os.Stdin, defaultFixturesDir(), os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "trust":
+			if err := runTrustCommand(os.Args[2:], defaultTrustFile(), os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "validate":
+			if err := runValidateCommand(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	configPath := flag.String("config", "", "Path to config file")
+	refreshAction := flag.String("refresh", "", "Refresh the cached value of the named action (used internally for stale_ttl)")
+	record := flag.String("record", "", "Save the stdin payload as the fixture `name` for preview -fixture")
+	trace := flag.Bool("trace", false, "Report how each action ran to stderr after the statusline")
+	traceFormat := flag.String("trace-format", traceFormatTable, "Format of -trace: table or json")
 	flag.Parse()
 
+	if err := validateTraceFormat(*traceFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Claude Code discards stderr, so errors also go to the log
+	logger := NewDefaultLogger()
+
 	// Read JSON from stdin
 	inputJSON, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+		logger.Error("failed to read stdin", map[string]interface{}{"error": err.Error()})
 		os.Exit(1)
 	}
 
 	var inputData map[string]interface{}
 	if err := json.Unmarshal(inputJSON, &inputData); err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing JSON: %v\n", err)
+		logger.Error("failed to parse input", map[string]interface{}{"error": err.Error()})
 		os.Exit(1)
 	}
 
+	// Recording must not break the statusline, so a failure is only a warning
+	if *record != "" {
+		if err := recordFixture(defaultFixturesDir(), *record, inputJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to record fixture: %v\n", err)
+		}
+	}
+
 	// Load config
 	config, err := LoadConfig(*configPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		logger.Error("failed to load config", map[string]interface{}{"path": resolveConfigPath(*configPath), "error": err.Error()})
 		os.Exit(1)
 	}
 
-	// Process actions
+	// Merge the .ccstatusline.yaml of the project, if trusted; a refresh
+	// started by a render gets the same input and so the same actions
+	config = projectConfigFor(config, inputData, defaultTrustFile(), os.Stderr, logger)
+
 	processor := NewProcessor(inputData)
+	processor.logger = logger
+
+	// Background refresh of a single stale cache entry
+	if *refreshAction != "" {
+		if err := processor.Refresh(config, *refreshAction); err != nil {
+			fmt.Fprintf(os.Stderr, "Error refreshing: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Process actions
+	start := time.Now()
 	output, err := processor.Process(config)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error processing: %v\n", err)
+		logger.Error("failed to process", map[string]interface{}{"error": err.Error()})
 		os.Exit(1)
 	}
 
 	// Output result
 	fmt.Print(output)
+
+	if *trace {
+		fmt.Fprintln(os.Stderr)
+		if err := writeTrace(os.Stderr, output, processor.Trace(), time.Since(start), *traceFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing trace: %v\n", err)
+		}
+	}
 }
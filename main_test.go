@@ -8,6 +8,18 @@ import (
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	// Keep the failures logged by tests out of the real state directory
+	dir, err := os.MkdirTemp("", "ccstatusline-state-")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("XDG_STATE_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestMainIntegrationSimple(t *testing.T) {
 	// Create test config with new structure
 	tmpDir := t.TempDir()
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const previewUsage = `Usage: ccstatusline preview [options]
+
+Renders the config against sample Claude Code payloads, running the actions
+for real. Without -fixture every built-in sample is rendered.
+
+Options:
+  -config PATH     Config file (default: the usual config location)
+  -fixture NAME    Render only this sample or recorded fixture (a .json path also works)
+  -list            List the built-in samples and recorded fixtures
+
+Fixtures are recorded from a live session with ` + "`ccstatusline -record NAME`" + `
+as the statusline command; the payload of each render is saved to the
+fixtures directory and the statusline is printed as usual.
+`
+
+// fixtureExt is the extension of recorded fixtures
+const fixtureExt = ".json"
+
+// previewSamples are built-in payloads shaped like the ones Claude Code sends.
+// cwd and project_dir are the current directory, so git and file based
+// actions show something meaningful.
+func previewSamples(cwd string) map[string]map[string]interface{} {
+	payload := func(modelID, displayName, cwd, projectDir string, costUSD float64) map[string]interface{} {
+		return map[string]interface{}{
+			"hook_event_name": "Status",
+			"session_id":      "0f3c5a1e-8b2d-4e6f-9a7c-1d2e3f4a5b6c",
+			"transcript_path": "",
+			"cwd":             cwd,
+			"model": map[string]interface{}{
+				"id":           modelID,
+				"display_name": displayName,
+			},
+			"workspace": map[string]interface{}{
+				"current_dir": cwd,
+				"project_dir": projectDir,
+			},
+			"version": "2.0.0",
+			"output_style": map[string]interface{}{
+				"name": "default",
+			},
+			"cost": map[string]interface{}{
+				"total_cost_usd":        costUSD,
+				"total_duration_ms":     754000,
+				"total_api_duration_ms": 121000,
+				"total_lines_added":     156,
+				"total_lines_removed":   23,
+			},
+			"exceeds_200k_tokens": false,
+		}
+	}
+
+	return map[string]map[string]interface{}{
+		"sonnet":       payload("claude-sonnet-4-5-20250929", "Sonnet 4.5", cwd, cwd, 0.42),
+		"opus":         payload("claude-opus-4-1-20250805", "Opus 4.1", cwd, cwd, 3.87),
+		"subdirectory": payload("claude-sonnet-4-5-20250929", "Sonnet 4.5", filepath.Join(cwd, "src", "internal"), cwd, 0.05),
+	}
+}
+
+// defaultDataDir returns the ccstatusline directory for persistent data,
+// following the XDG Base Directory specification
+func defaultDataDir() string {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			dataDir = filepath.Join(os.TempDir(), "ccstatusline-data")
+		} else {
+			dataDir = filepath.Join(homeDir, ".local", "share")
+		}
+	}
+	return filepath.Join(dataDir, "ccstatusline")
+}
+
+// defaultFixturesDir returns where recorded fixtures are kept
+func defaultFixturesDir() string {
+	return filepath.Join(defaultDataDir(), "fixtures")
+}
+
+// runPreviewCommand implements `ccstatusline preview`
+func runPreviewCommand(args []string, fixturesDir string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configPath := flags.String("config", "", "Path to config file")
+	fixture := flags.String("fixture", "", "Sample or recorded fixture to render")
+	list := flags.Bool("list", false, "List samples and fixtures")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprint(stdout, previewUsage)
+			return nil
+		}
+		return fmt.Errorf("%w\n\n%s", err, previewUsage)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q\n\n%s", flags.Arg(0), previewUsage)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	samples := previewSamples(cwd)
+
+	if *list {
+		return previewList(samples, fixturesDir, stdout)
+	}
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if *fixture != "" {
+		input, err := loadFixture(*fixture, samples, fixturesDir)
+		if err != nil {
+			return err
+		}
+		output, err := previewRender(config, input)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, output)
+		return nil
+	}
+
+	for i, name := range sortedKeys(samples) {
+		output, err := previewRender(config, samples[name])
+		if err != nil {
+			return fmt.Errorf("sample %s: %w", name, err)
+		}
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		fmt.Fprintf(stdout, "== %s ==\n%s\n", name, output)
+	}
+	return nil
+}
+
+// previewRender renders input with a throwaway cache and no background
+// refreshes. The samples share the cwd and so the cache keys, and a preview
+// must not leave its values for the live statusline either.
+func previewRender(config *Config, input map[string]interface{}) (string, error) {
+	cacheDir, err := os.MkdirTemp("", "ccstatusline-preview-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(cacheDir)
+
+	processor := NewProcessor(input)
+	processor.cache = NewCache(cacheDir)
+	processor.refresh = func(action Action) error { return nil }
+	return processor.Process(projectConfigFor(config, input, defaultTrustFile(), os.Stderr, nil))
+}
+
+// previewList prints the names accepted by -fixture
+func previewList(samples map[string]map[string]interface{}, fixturesDir string, stdout io.Writer) error {
+	for _, name := range sortedKeys(samples) {
+		fmt.Fprintf(stdout, "%s (built-in)\n", name)
+	}
+
+	entries, err := os.ReadDir(fixturesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), fixtureExt); ok && !entry.IsDir() {
+			fmt.Fprintf(stdout, "%s (%s)\n", name, filepath.Join(fixturesDir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// loadFixture returns the payload of a recorded fixture, a built-in sample or
+// a JSON file, in that order
+func loadFixture(name string, samples map[string]map[string]interface{}, fixturesDir string) (map[string]interface{}, error) {
+	path := ""
+	if strings.ContainsRune(name, filepath.Separator) || strings.HasSuffix(name, fixtureExt) {
+		path = name
+	} else if candidate := filepath.Join(fixturesDir, name+fixtureExt); fileExists(candidate) {
+		path = candidate
+	} else if sample, ok := samples[name]; ok {
+		return sample, nil
+	} else {
+		return nil, fmt.Errorf("unknown fixture %q (see ccstatusline preview -list)", name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	var input map[string]interface{}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, fmt.Errorf("failed to parse fixture %s: %w", path, err)
+	}
+	return input, nil
+}
+
+// recordFixture saves a payload received on stdin as the fixture name
+func recordFixture(fixturesDir, name string, payload []byte) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid fixture name %q", name)
+	}
+	if err := os.MkdirAll(fixturesDir, 0755); err != nil {
+		return err
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, payload, "", "  "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	return writeFileAtomic(filepath.Join(fixturesDir, name+fixtureExt), indented.Bytes())
+}
+
+// fileExists reports whether path names an existing regular file
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
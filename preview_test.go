@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreviewCommand(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "actions:\n  - name: model\n    template: \"{.model.display_name}\"\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	fixturesDir := t.TempDir()
+	if err := recordFixture(fixturesDir, "live", []byte(`{"model": {"display_name": "Recorded"}}`)); err != nil {
+		t.Fatalf("recordFixture() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "all samples",
+			args:     []string{"-config", configPath},
+			expected: "== opus ==\nOpus 4.1\n\n== sonnet ==\nSonnet 4.5\n\n== subdirectory ==\nSonnet 4.5\n",
+		},
+		{name: "sample", args: []string{"-config", configPath, "-fixture", "opus"}, expected: "Opus 4.1\n"},
+		{name: "recorded fixture", args: []string{"-config", configPath, "-fixture", "live"}, expected: "Recorded\n"},
+		{name: "fixture path", args: []string{"-config", configPath, "-fixture", filepath.Join(fixturesDir, "live.json")}, expected: "Recorded\n"},
+		{name: "unknown fixture", args: []string{"-config", configPath, "-fixture", "nope"}, wantErr: true},
+		{
+			name:     "list",
+			args:     []string{"-list"},
+			expected: "opus (built-in)\nsonnet (built-in)\nsubdirectory (built-in)\nlive (" + filepath.Join(fixturesDir, "live.json") + ")\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			err := runPreviewCommand(tt.args, fixturesDir, &stdout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runPreviewCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := stdout.String(); got != tt.expected {
+				t.Errorf("output = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPreviewCommandIsolatesCache(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "actions:\n  - name: model\n    command: \"echo {@sh .model.display_name}\"\n    cache_ttl: 60\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cacheHome := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheHome)
+
+	var stdout bytes.Buffer
+	if err := runPreviewCommand([]string{"-config", configPath}, t.TempDir(), &stdout); err != nil {
+		t.Fatalf("runPreviewCommand() error = %v", err)
+	}
+	expected := "== opus ==\nOpus 4.1\n\n== sonnet ==\nSonnet 4.5\n\n== subdirectory ==\nSonnet 4.5\n"
+	if got := stdout.String(); got != expected {
+		t.Errorf("output = %q, want %q", got, expected)
+	}
+
+	// Nothing is left in the user's cache for the live statusline to read
+	entries, err := os.ReadDir(filepath.Join(cacheHome, "ccstatusline"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if len(entries) > 0 {
+		t.Errorf("preview wrote %d entries to the user cache", len(entries))
+	}
+}
+
+func TestRecordFixture(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "fixtures")
+
+	if err := recordFixture(dir, "session", []byte(`{"cwd":"/tmp"}`)); err != nil {
+		t.Fatalf("recordFixture() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "session.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "{\n  \"cwd\": \"/tmp\"\n}\n" {
+		t.Errorf("fixture = %q", got)
+	}
+
+	for _, name := range []string{"", "../escape", "a/b", ".hidden"} {
+		if err := recordFixture(dir, name, []byte(`{}`)); err == nil {
+			t.Errorf("recordFixture(%q) should fail", name)
+		}
+	}
+	if err := recordFixture(dir, "broken", []byte(`{`)); err == nil || !strings.Contains(err.Error(), "unexpected end") {
+		t.Errorf("recordFixture() with invalid JSON error = %v", err)
+	}
+}
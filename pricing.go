@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// defaultCostFormat is the printf format of .ccstatusline.cost.formatted
+const defaultCostFormat = "$%.2f"
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Input      float64 `yaml:"input"`
+	Output     float64 `yaml:"output"`
+	CacheRead  float64 `yaml:"cache_read"`
+	CacheWrite float64 `yaml:"cache_write"`
+}
+
+// defaultPricing holds the list prices of Claude models, keyed by model id prefix.
+// The longest matching prefix wins, so "claude-opus-4-1" takes precedence over "claude-opus-4".
+var defaultPricing = map[string]ModelPrice{
+	"claude-opus-4-5":   {Input: 5, Output: 25, CacheRead: 0.5, CacheWrite: 6.25},
+	"claude-opus-4-1":   {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-opus-4":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-sonnet-4-5": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-sonnet-4":   {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-haiku-4-5":  {Input: 1, Output: 5, CacheRead: 0.1, CacheWrite: 1.25},
+	"claude-3-7-sonnet": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-5-sonnet": {Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1},
+	"claude-3-opus":     {Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.3},
+}
+
+// lookupPrice returns the price of a model from the built-in table with
+// overrides applied, matching the longest model id prefix
+func lookupPrice(modelID string, overrides map[string]ModelPrice) (ModelPrice, bool) {
+	id := strings.TrimSuffix(strings.ToLower(modelID), "[1m]")
+
+	best := ""
+	var price ModelPrice
+	for _, table := range []map[string]ModelPrice{defaultPricing, overrides} {
+		for prefix, p := range table {
+			// An override with the same prefix replaces the built-in price
+			if strings.HasPrefix(id, strings.ToLower(prefix)) && len(prefix) >= len(best) {
+				best, price = prefix, p
+			}
+		}
+	}
+	return price, best != ""
+}
+
+// cost returns the cost in USD of usage at price
+func (p ModelPrice) cost(usage tokenUsage) float64 {
+	return (float64(usage.Input)*p.Input +
+		float64(usage.Output)*p.Output +
+		float64(usage.CacheRead)*p.CacheRead +
+		float64(usage.CacheCreation)*p.CacheWrite) / 1e6
+}
+
+// costFields returns the template fields of the estimated session cost,
+// exposed as .ccstatusline.cost. Each model in the transcript is priced
+// separately, falling back to modelID (model.id of the input) for messages
+// that don't name theirs. Models without a known price are listed in unpriced_models.
+func costFields(state transcriptState, modelID string, config CostConfig) map[string]interface{} {
+	models := state.Models
+	if len(models) == 0 && state.Usage != (tokenUsage{}) {
+		models = map[string]tokenUsage{modelID: state.Usage}
+	}
+
+	usd := 0.0
+	unpriced := []string{}
+	for model, usage := range models {
+		price, ok := lookupPrice(model, config.Pricing)
+		if !ok {
+			unpriced = append(unpriced, model)
+			continue
+		}
+		usd += price.cost(usage)
+	}
+	sort.Strings(unpriced)
+
+	rate := config.Rate
+	if rate == 0 {
+		rate = 1
+	}
+	format := config.Format
+	if format == "" {
+		format = defaultCostFormat
+	}
+	amount := usd * rate
+
+	return map[string]interface{}{
+		"usd":             math.Round(usd*1e6) / 1e6,
+		"amount":          math.Round(amount*1e6) / 1e6,
+		"formatted":       fmt.Sprintf(format, amount),
+		"unpriced_models": unpriced,
+	}
+}
+
+// validateCostFormat checks that format formats exactly one number
+func validateCostFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	if formatted := fmt.Sprintf(format, 1.0); strings.Contains(formatted, "%!") {
+		return fmt.Errorf("cost.format %q must contain exactly one number verb such as %%.2f", format)
+	}
+	return nil
+}
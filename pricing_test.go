@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestLookupPrice(t *testing.T) {
+	overrides := map[string]ModelPrice{
+		"claude-sonnet-4": {Input: 1, Output: 2},
+		"my-local-model":  {Input: 0.1},
+	}
+
+	tests := []struct {
+		modelID   string
+		overrides map[string]ModelPrice
+		expected  ModelPrice
+		found     bool
+	}{
+		{modelID: "claude-opus-4-1-20250805", expected: defaultPricing["claude-opus-4-1"], found: true},
+		{modelID: "claude-opus-4-20250514", expected: defaultPricing["claude-opus-4"], found: true},
+		{modelID: "claude-sonnet-4-5-20250929[1m]", expected: defaultPricing["claude-sonnet-4-5"], found: true},
+		{modelID: "claude-sonnet-4-20250514", overrides: overrides, expected: ModelPrice{Input: 1, Output: 2}, found: true},
+		// A longer built-in prefix still wins over a shorter override
+		{modelID: "claude-sonnet-4-5-20250929", overrides: overrides, expected: defaultPricing["claude-sonnet-4-5"], found: true},
+		{modelID: "my-local-model-v2", overrides: overrides, expected: ModelPrice{Input: 0.1}, found: true},
+		{modelID: "gpt-5", found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.modelID, func(t *testing.T) {
+			price, found := lookupPrice(tt.modelID, tt.overrides)
+			if found != tt.found || price != tt.expected {
+				t.Errorf("lookupPrice(%q) = %+v, %v, want %+v, %v", tt.modelID, price, found, tt.expected, tt.found)
+			}
+		})
+	}
+}
+
+func TestCostFields(t *testing.T) {
+	state := transcriptState{
+		Usage: tokenUsage{Input: 2000000, Output: 200000, CacheRead: 1000000, CacheCreation: 100000},
+		Models: map[string]tokenUsage{
+			"claude-sonnet-4-20250514":  {Input: 1000000, Output: 100000, CacheRead: 1000000, CacheCreation: 100000},
+			"claude-3-5-haiku-20241022": {Input: 1000000, Output: 100000},
+			"<synthetic>":               {},
+		},
+	}
+
+	fields := costFields(state, "claude-sonnet-4-20250514", CostConfig{Format: "¥%.0f", Rate: 150})
+
+	// sonnet: 3 + 1.5 + 0.3 + 0.375, haiku: 0.8 + 0.4
+	if usd := fields["usd"].(float64); math.Abs(usd-6.375) > 1e-9 {
+		t.Errorf("usd = %v, want 6.375", usd)
+	}
+	if formatted := fields["formatted"]; formatted != "¥956" {
+		t.Errorf("formatted = %v, want ¥956", formatted)
+	}
+	if unpriced := fields["unpriced_models"]; !reflect.DeepEqual(unpriced, []string{"<synthetic>"}) {
+		t.Errorf("unpriced_models = %v", unpriced)
+	}
+
+	// Without per-model usage the input's model.id is used
+	fields = costFields(transcriptState{Usage: tokenUsage{Output: 1000000}}, "claude-opus-4-1", CostConfig{})
+	if fields["formatted"] != "$75.00" {
+		t.Errorf("formatted = %v, want $75.00", fields["formatted"])
+	}
+}
+
+func TestCostProblems(t *testing.T) {
+	tests := []struct {
+		name    string
+		cost    CostConfig
+		wantErr bool
+	}{
+		{name: "defaults", cost: CostConfig{}},
+		{name: "custom format", cost: CostConfig{Format: "%.3f USD"}},
+		{name: "no verb", cost: CostConfig{Format: "USD"}, wantErr: true},
+		{name: "integer verb", cost: CostConfig{Format: "%d"}, wantErr: true},
+		{name: "two verbs", cost: CostConfig{Format: "%.2f %.2f"}, wantErr: true},
+		{name: "negative rate", cost: CostConfig{Rate: -1}, wantErr: true},
+		{name: "negative price", cost: CostConfig{Pricing: map[string]ModelPrice{"x": {Output: -1}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := firstError(costProblems(tt.cost)); (err != nil) != tt.wantErr {
+				t.Errorf("costProblems() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"os/exec"
+	"time"
+)
+
+// killProcessGroupOnCancel falls back to killing only the direct child
+// on platforms without process groups.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.WaitDelay = 500 * time.Millisecond
+}
+
+// detachProcess is a no-op on platforms without sessions
+func detachProcess(cmd *exec.Cmd) {}
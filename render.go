@@ -0,0 +1,169 @@
+package main
+
+import "strings"
+
+// Layouts of the statusline
+const (
+	layoutPlain     = "plain"
+	layoutPowerline = "powerline"
+)
+
+// Alignments of a line
+const (
+	alignLeft   = "left"
+	alignRight  = "right"
+	alignCenter = "center"
+)
+
+// Default powerline glyphs (Nerd Fonts / powerline-patched fonts)
+const (
+	defaultPowerlineSeparator     = "\ue0b0"
+	defaultPowerlineThinSeparator = "\ue0b1"
+)
+
+// segment is the output of one action, ready to be laid out
+type segment struct {
+	text     string // Output with prefix, without styling
+	style    textStyle
+	priority int
+}
+
+// newSegment builds the segment of a non-empty action output styled by styleSpec
+func newSegment(action Action, output, styleSpec string) (segment, bool) {
+	// If output is empty, don't show prefix
+	if output == "" {
+		return segment{}, false
+	}
+
+	// Unknown colors are rejected by config validation; render them unstyled
+	style, _ := parseStyle(styleSpec)
+	text := action.Prefix + output
+	if action.MaxWidth > 0 {
+		text = truncateWidth(text, action.MaxWidth)
+	}
+	return segment{text: text, style: style, priority: action.Priority}, true
+}
+
+// renderLine renders segments, dropping the lowest-priority ones while the line
+// is wider than maxWidth cells (0 = no limit). If a single segment is still too
+// wide, the line is truncated with an ellipsis.
+func renderLine(segments []segment, config *Config, separator string, depth colorDepth, maxWidth int) string {
+	line := renderSegments(segments, config, separator, depth)
+	if maxWidth <= 0 {
+		return line
+	}
+
+	for len(segments) > 1 && displayWidth(line) > maxWidth {
+		segments = dropLowestPriority(segments)
+		line = renderSegments(segments, config, separator, depth)
+	}
+	return truncateWidth(line, maxWidth)
+}
+
+// dropLowestPriority removes the segment with the lowest priority,
+// the rightmost one among equals
+func dropLowestPriority(segments []segment) []segment {
+	drop := len(segments) - 1
+	for i := len(segments) - 2; i >= 0; i-- {
+		if segments[i].priority < segments[drop].priority {
+			drop = i
+		}
+	}
+	return append(segments[:drop:drop], segments[drop+1:]...)
+}
+
+// renderSegments lays out the segments according to config.Layout;
+// the plain layout joins them with separator
+func renderSegments(segments []segment, config *Config, separator string, depth colorDepth) string {
+	if config.Layout == layoutPowerline {
+		return renderPowerline(segments, config.Powerline, depth)
+	}
+
+	outputs := make([]string, len(segments))
+	for i, seg := range segments {
+		outputs[i] = applyStyle(seg.text, seg.style, depth)
+	}
+	return strings.Join(outputs, separator)
+}
+
+// renderPowerline renders segments padded by a space and joined by arrow glyphs.
+// A glyph between two backgrounds takes the left background as its foreground
+// and the right background as its background; between segments sharing a
+// background the thin separator is drawn in the left segment's colors instead.
+func renderPowerline(segments []segment, glyphs Powerline, depth colorDepth) string {
+	if len(segments) == 0 {
+		return ""
+	}
+
+	separator := glyphs.Separator
+	if separator == "" {
+		separator = defaultPowerlineSeparator
+	}
+	thinSeparator := glyphs.ThinSeparator
+	if thinSeparator == "" {
+		thinSeparator = defaultPowerlineThinSeparator
+	}
+
+	var b strings.Builder
+	if glyphs.LeftCap != "" {
+		b.WriteString(applyStyle(glyphs.LeftCap, textStyle{fg: segments[0].style.bg}, depth))
+	}
+
+	for i, seg := range segments {
+		b.WriteString(applyStyle(" "+seg.text+" ", seg.style, depth))
+
+		if i == len(segments)-1 {
+			break
+		}
+		next := segments[i+1]
+		if sameColor(seg.style.bg, next.style.bg) {
+			b.WriteString(applyStyle(thinSeparator, textStyle{fg: seg.style.fg, bg: seg.style.bg}, depth))
+		} else {
+			b.WriteString(applyStyle(separator, textStyle{fg: seg.style.bg, bg: next.style.bg}, depth))
+		}
+	}
+
+	if glyphs.RightCap != "" {
+		b.WriteString(applyStyle(glyphs.RightCap, textStyle{fg: segments[len(segments)-1].style.bg}, depth))
+	}
+
+	return b.String()
+}
+
+// sameColor reports whether two optional colors are equal; unset means the terminal default
+func sameColor(a, b *termColor) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// renderedLine is a rendered line waiting to be aligned
+type renderedLine struct {
+	text  string
+	align string
+}
+
+// alignLines joins lines with newlines, padding right and center aligned ones
+// within width cells, or within the widest line when width is 0
+func alignLines(lines []renderedLine, width int) string {
+	if width <= 0 {
+		for _, line := range lines {
+			width = max(width, displayWidth(line.text))
+		}
+	}
+
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		padding := max(width-displayWidth(line.text), 0)
+		switch line.align {
+		case alignRight:
+			texts[i] = strings.Repeat(" ", padding) + line.text
+		case alignCenter:
+			texts[i] = strings.Repeat(" ", padding/2) + line.text
+		default:
+			texts[i] = line.text
+		}
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,189 @@
+package main
+
+import "testing"
+
+func TestRenderPowerline(t *testing.T) {
+	seg := func(text, spec string) segment {
+		style, err := parseStyle(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return segment{text: text, style: style}
+	}
+
+	tests := []struct {
+		name     string
+		segments []segment
+		glyphs   Powerline
+		expected string
+	}{
+		{
+			name:     "transition takes left bg as fg and right bg as bg",
+			segments: []segment{seg("a", "fg:black bg:blue"), seg("b", "fg:black bg:green")},
+			glyphs:   Powerline{Separator: ">"},
+			expected: "\033[30;44m a \033[0m\033[34;42m>\033[0m\033[30;42m b \033[0m",
+		},
+		{
+			name:     "thin separator within the same background",
+			segments: []segment{seg("a", "fg:white bg:blue"), seg("b", "fg:black bg:blue")},
+			glyphs:   Powerline{ThinSeparator: "|"},
+			expected: "\033[37;44m a \033[0m\033[37;44m|\033[0m\033[30;44m b \033[0m",
+		},
+		{
+			name:     "caps",
+			segments: []segment{seg("a", "bg:#102030")},
+			glyphs:   Powerline{LeftCap: "(", RightCap: ")"},
+			expected: "\033[38;2;16;32;48m(\033[0m\033[48;2;16;32;48m a \033[0m\033[38;2;16;32;48m)\033[0m",
+		},
+		{
+			name:     "default glyphs and unstyled neighbour",
+			segments: []segment{seg("a", "bg:red"), seg("b", ""), seg("c", "")},
+			expected: "\033[41m a \033[0m\033[31m\033[0m b  c ",
+		},
+		{
+			name:     "no segments",
+			glyphs:   Powerline{LeftCap: "(", RightCap: ")"},
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := renderPowerline(tt.segments, tt.glyphs, colorDepthTrueColor)
+			if result != tt.expected {
+				t.Errorf("renderPowerline() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessorPowerlineLayout(t *testing.T) {
+	config := &Config{
+		Actions: []Action{
+			{Name: "a", Template: "A", Style: "fg:black bg:blue"},
+			{Name: "empty", Template: "", Style: "bg:red"},
+			{Name: "b", Template: "B", Prefix: "> ", Style: "fg:black bg:yellow"},
+		},
+		Separator: " | ",
+		Layout:    layoutPowerline,
+		Powerline: Powerline{Separator: "/"},
+	}
+
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(t.TempDir())
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	expected := "\033[30;44m A \033[0m\033[34;43m/\033[0m\033[30;43m > B \033[0m"
+	if result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+}
+
+func TestRenderLine(t *testing.T) {
+	segments := []segment{
+		{text: "model", priority: 1},
+		{text: "dir", priority: 0},
+		{text: "branch", priority: 2},
+		{text: "time", priority: 0},
+	}
+	config := &Config{Separator: " | "}
+
+	tests := []struct {
+		name     string
+		maxWidth int
+		expected string
+	}{
+		{name: "no limit", maxWidth: 0, expected: "model | dir | branch | time"},
+		{name: "fits", maxWidth: 27, expected: "model | dir | branch | time"},
+		{name: "drops rightmost of lowest priority first", maxWidth: 26, expected: "model | dir | branch"},
+		{name: "drops next lowest priority", maxWidth: 17, expected: "model | branch"},
+		{name: "keeps highest priority", maxWidth: 13, expected: "branch"},
+		{name: "truncates last segment", maxWidth: 4, expected: "bra…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := renderLine(segments, config, config.Separator, colorDepthTrueColor, tt.maxWidth)
+			if result != tt.expected {
+				t.Errorf("renderLine(%d) = %q, want %q", tt.maxWidth, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessorMaxWidth(t *testing.T) {
+	t.Setenv("COLUMNS", "25")
+	config := &Config{
+		Actions: []Action{
+			{Name: "dir", Template: "ccstatusline"},
+			{Name: "branch", Template: "feature/very-long-branch-name", MaxWidth: 10, Priority: 1},
+			{Name: "model", Template: "日本語モデル", Priority: 2, Color: "cyan"},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(t.TempDir())
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	expected := "feature/v… | \033[36m日本語モデル\033[0m"
+	if result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+}
+
+func TestAlignLines(t *testing.T) {
+	lines := []renderedLine{
+		{text: "left"},
+		{text: "right", align: alignRight},
+		{text: "日本", align: alignCenter},
+	}
+
+	if got, want := alignLines(lines, 10), "left\n     right\n   日本"; got != want {
+		t.Errorf("alignLines(10) = %q, want %q", got, want)
+	}
+	// Without a width, lines are aligned within the widest one
+	if got, want := alignLines(lines, 0), "left\nright\n日本"; got != want {
+		t.Errorf("alignLines(0) = %q, want %q", got, want)
+	}
+}
+
+func TestProcessorLines(t *testing.T) {
+	config := &Config{
+		Lines: []Line{
+			{Actions: []Action{
+				{Name: "model", Template: "Opus"},
+				{Name: "dir", Template: "repo"},
+			}},
+			// Lines without output are left out
+			{Actions: []Action{{Name: "empty", Template: ""}}},
+			{
+				Separator: " / ",
+				Align:     alignRight,
+				Actions: []Action{
+					{Name: "upper", Command: "echo \"$CCSTATUSLINE_ACTION_MODEL\" | tr a-z A-Z", DependsOn: []string{"model"}},
+					{Name: "time", Template: "12:00"},
+				},
+			},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{})
+	processor.cache = NewCache(t.TempDir())
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	expected := "Opus | repo\nOPUS / 12:00"
+	if result != expected {
+		t.Errorf("Process() = %q, want %q", result, expected)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -14,43 +15,106 @@ import (
 
 // JQ query cache for performance
 var (
-	jqQueryCache = make(map[string]*gojq.Query)
+	jqQueryCache = make(map[string]*gojq.Code)
 	jqCacheMutex sync.RWMutex
 )
 
+// jqVars holds the values of jq variables such as $actions, keyed by name including the $
+type jqVars map[string]interface{}
+
 // executeJQQuery executes a gojq query and returns the result as a string
-func executeJQQuery(queryStr string, input interface{}) (string, error) {
+func executeJQQuery(queryStr string, input interface{}, vars jqVars) (string, error) {
+	results, err := runJQQuery(queryStr, input, vars)
+	if err != nil {
+		return "", err
+	}
+
+	// Convert results to string
+	switch len(results) {
+	case 0:
+		return "", nil
+	case 1:
+		return jqValueToString(results[0]), nil
+	default:
+		// Return as JSON array for multiple results
+		resultJSON, err := json.Marshal(results)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal jq results: %w", err)
+		}
+		return string(resultJSON), nil
+	}
+}
+
+// evaluateJQCondition executes a gojq query and reports whether its first
+// result is truthy in the jq sense (anything but false and null).
+// A query without results is false.
+func evaluateJQCondition(queryStr string, input interface{}, vars jqVars) (bool, error) {
+	results, err := runJQQuery(queryStr, input, vars)
+	if err != nil {
+		return false, err
+	}
+	if len(results) == 0 {
+		return false, nil
+	}
+
+	switch v := results[0].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	default:
+		return true, nil
+	}
+}
+
+// runJQQuery executes a gojq query using the compiled-query cache and returns all results
+func runJQQuery(queryStr string, input interface{}, vars jqVars) ([]interface{}, error) {
+	// Variables are bound by position, so compile against a stable order of names
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]interface{}, len(names))
+	for i, name := range names {
+		values[i] = vars[name]
+	}
+	cacheKey := strings.Join(names, ",") + "\x00" + queryStr
+
 	// Get query from cache or create new one
 	jqCacheMutex.RLock()
-	query, exists := jqQueryCache[queryStr]
+	code, exists := jqQueryCache[cacheKey]
 	jqCacheMutex.RUnlock()
 
 	if !exists {
-		// Parse query and cache it
-		var err error
-		query, err = gojq.Parse(queryStr)
+		// Parse and compile query and cache it
+		query, err := gojq.Parse(queryStr)
 		if err != nil {
-			return "", fmt.Errorf("invalid jq query '%s': %w", queryStr, err)
+			return nil, fmt.Errorf("invalid jq query '%s': %w", queryStr, err)
+		}
+		code, err = gojq.Compile(query, gojq.WithVariables(names))
+		if err != nil {
+			return nil, fmt.Errorf("invalid jq query '%s': %w", queryStr, err)
 		}
 
 		jqCacheMutex.Lock()
-		jqQueryCache[queryStr] = query
+		jqQueryCache[cacheKey] = code
 		jqCacheMutex.Unlock()
 	}
 
 	// Convert input to gojq-compatible type
 	inputJSON, err := json.Marshal(input)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal input to JSON: %w", err)
+		return nil, fmt.Errorf("failed to marshal input to JSON: %w", err)
 	}
 
 	var gojqInput interface{}
 	if err := json.Unmarshal(inputJSON, &gojqInput); err != nil {
-		return "", fmt.Errorf("failed to unmarshal JSON for gojq: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal JSON for gojq: %w", err)
 	}
 
 	// Execute query
-	iter := query.Run(gojqInput)
+	iter := code.Run(gojqInput, values...)
 	var results []interface{}
 
 	for {
@@ -59,25 +123,12 @@ func executeJQQuery(queryStr string, input interface{}) (string, error) {
 			break
 		}
 		if err, ok := v.(error); ok {
-			return "", fmt.Errorf("jq query execution error: %w", err)
+			return nil, fmt.Errorf("jq query execution error: %w", err)
 		}
 		results = append(results, v)
 	}
 
-	// Convert results to string
-	switch len(results) {
-	case 0:
-		return "", nil
-	case 1:
-		return jqValueToString(results[0]), nil
-	default:
-		// Return as JSON array for multiple results
-		resultJSON, err := json.Marshal(results)
-		if err != nil {
-			return "", fmt.Errorf("failed to marshal jq results: %w", err)
-		}
-		return string(resultJSON), nil
-	}
+	return results, nil
 }
 
 // jqValueToString converts a gojq result value to string
@@ -124,31 +175,88 @@ func processTemplate(template string, data map[string]interface{}) string {
 	})
 
 	// Then process template placeholders {.field}
-	pattern := regexp.MustCompile(`\{([^}]+)\}`)
-	return pattern.ReplaceAllStringFunc(template, func(match string) string {
+	return expandTemplates(template, data, nil)
+}
+
+// templatePattern matches {.field} placeholders
+var templatePattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// shQuotePrefix marks a placeholder whose value is shell-quoted: {@sh .field}
+const shQuotePrefix = "@sh"
+
+// expandTemplates only expands {.field} templates, not shell commands.
+// A placeholder that fails is rendered as [ERROR: ...].
+func expandTemplates(template string, data map[string]interface{}, vars jqVars) string {
+	expanded, _ := expandPlaceholders(template, data, vars)
+	return expanded
+}
+
+// expandCommandTemplates expands the placeholders of a command. Unlike
+// expandTemplates it fails if any placeholder fails: the error message may
+// contain input values, so pasting it into the command unquoted would let
+// the input run as shell code.
+func expandCommandTemplates(command string, data map[string]interface{}, vars jqVars) (string, error) {
+	expanded, err := expandPlaceholders(command, data, vars)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand command: %w", err)
+	}
+	return expanded, nil
+}
+
+// expandPlaceholders expands {.field} placeholders, rendering failed ones as
+// [ERROR: ...] and returning the first error
+func expandPlaceholders(template string, data map[string]interface{}, vars jqVars) (string, error) {
+	var firstErr error
+	expanded := templatePattern.ReplaceAllStringFunc(template, func(match string) string {
 		content := strings.TrimSpace(match[1 : len(match)-1]) // Remove {}
 
 		// Process as JQ query
-		result, err := executeJQQuery(content, data)
+		result, err := executeJQQuery(placeholderQuery(content), data, vars)
 		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
 			return fmt.Sprintf("[ERROR: %s]", err.Error())
 		}
 		return result
 	})
+	return expanded, firstErr
 }
 
-// expandTemplates only expands {.field} templates, not shell commands
-func expandTemplates(template string, data map[string]interface{}) string {
-	// Process template placeholders {.field}
-	pattern := regexp.MustCompile(`\{([^}]+)\}`)
-	return pattern.ReplaceAllStringFunc(template, func(match string) string {
-		content := strings.TrimSpace(match[1 : len(match)-1]) // Remove {}
+// placeholderQuery turns the content of a placeholder into a jq query.
+// {@sh .field} becomes a query that quotes the value with gojq's @sh format,
+// so it can be pasted into a shell command safely. null becomes an empty quoted string.
+func placeholderQuery(content string) string {
+	query, ok := shQuotedQuery(content)
+	if !ok {
+		return content
+	}
+	return fmt.Sprintf("(%s) | if . == null then \"\" else . end | @sh", query)
+}
 
-		// Process as JQ query
-		result, err := executeJQQuery(content, data)
-		if err != nil {
-			return fmt.Sprintf("[ERROR: %s]", err.Error())
+// shQuotedQuery reports whether content uses the {@sh ...} form and returns the inner query
+func shQuotedQuery(content string) (string, bool) {
+	rest, ok := strings.CutPrefix(content, shQuotePrefix)
+	if !ok {
+		return "", false
+	}
+	if rest == "" {
+		return ".", true
+	}
+	if rest[0] != ' ' && rest[0] != '\t' {
+		// Something like {@shell}, not the @sh form
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// unquotedPlaceholders returns the placeholders in a command that are not shell-quoted
+func unquotedPlaceholders(command string) []string {
+	var unquoted []string
+	for _, match := range templatePattern.FindAllStringSubmatch(command, -1) {
+		if _, ok := shQuotedQuery(strings.TrimSpace(match[1])); !ok {
+			unquoted = append(unquoted, match[0])
 		}
-		return result
-	})
+	}
+	return unquoted
 }
@@ -145,3 +145,97 @@ func TestJQValueToString(t *testing.T) {
 		})
 	}
 }
+
+func TestEvaluateJQCondition(t *testing.T) {
+	data := map[string]interface{}{
+		"cost":  map[string]interface{}{"total_cost_usd": 1.5},
+		"model": map[string]interface{}{"id": "claude-opus-4-1"},
+		"empty": "",
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected bool
+		wantErr  bool
+	}{
+		{name: "true comparison", query: ".cost.total_cost_usd > 1", expected: true},
+		{name: "false comparison", query: ".cost.total_cost_usd > 2", expected: false},
+		{name: "null is falsy", query: ".missing", expected: false},
+		{name: "empty string is truthy", query: ".empty", expected: true},
+		{name: "string test", query: `.model.id | startswith("claude-opus")`, expected: true},
+		{name: "no results is falsy", query: "empty", expected: false},
+		{name: "first result decides", query: "true, false", expected: true},
+		{name: "invalid query", query: ".a |", wantErr: true},
+		{name: "runtime error", query: ".model.id + 1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := evaluateJQCondition(tt.query, data, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluateJQCondition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("evaluateJQCondition() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExpandTemplatesShellQuoting(t *testing.T) {
+	data := map[string]interface{}{
+		"cwd":    "/home/o'brien/my project",
+		"evil":   "x'; touch /tmp/pwned; echo '",
+		"count":  3,
+		"branch": "main",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "quote with single quote", template: "cd {@sh .cwd}", expected: `cd '/home/o'\''brien/my project'`},
+		{name: "injection attempt", template: "echo {@sh .evil}", expected: `echo 'x'\''; touch /tmp/pwned; echo '\'''`},
+		{name: "number", template: "echo {@sh .count}", expected: "echo 3"},
+		{name: "missing field", template: "echo {@sh .missing}", expected: "echo ''"},
+		{name: "with filter", template: "echo {@sh .cwd | split(\"/\") | .[-1]}", expected: "echo 'my project'"},
+		{name: "unquoted stays raw", template: "echo {.branch}", expected: "echo main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := expandTemplates(tt.template, data, nil)
+			if result != tt.expected {
+				t.Errorf("expandTemplates() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUnquotedPlaceholders(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{command: "echo {@sh .cwd}", expected: nil},
+		{command: "echo {.cwd} {@sh .branch}", expected: []string{"{.cwd}"}},
+		{command: "echo {@shell} {.a}", expected: []string{"{@shell}", "{.a}"}},
+		{command: "git branch --show-current", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			result := unquotedPlaceholders(tt.command)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("unquotedPlaceholders() = %v, want %v", result, tt.expected)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("unquotedPlaceholders()[%d] = %q, want %q", i, result[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
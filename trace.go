@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const traceUsage = `Usage: ccstatusline trace [options]
+
+Renders the statusline once and reports how each action ran: status, cache
+hit or miss, duration, exit code, the expanded command, stderr, the raw
+output and the styled output. The payload is read from stdin unless
+-fixture is given.
+
+Options:
+  -config PATH     Config file (default: the usual config location)
+  -fixture NAME    Use a built-in sample or recorded fixture instead of stdin
+  -format FORMAT   table (default) or json
+`
+
+// Trace output formats
+const (
+	traceFormatTable = "table"
+	traceFormatJSON  = "json"
+)
+
+// Action statuses in a trace
+const (
+	traceOK         = "ok"          // Processed, possibly with an empty output
+	traceSkipped    = "skipped"     // The when condition didn't hold
+	traceFailed     = "failed"      // The command exited with an error
+	traceTimeout    = "timeout"     // The command was killed at its deadline
+	traceError      = "error"       // The action couldn't be evaluated, e.g. a broken when
+	traceNotStarted = "not started" // The global timeout expired before it could start
+)
+
+// Cache outcomes in a trace; empty when the action has no cache_ttl
+const (
+	traceCacheHit   = "hit"
+	traceCacheStale = "stale" // Served an expired value and refreshed it in the background
+	traceCacheMiss  = "miss"
+)
+
+// traceCellWidth is the width the free-form columns of the table are truncated to
+const traceCellWidth = 40
+
+// actionTrace records how an action ran during a render
+type actionTrace struct {
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Cache    string        `json:"cache,omitempty"`
+	Duration time.Duration `json:"-"`
+	Command  string        `json:"command,omitempty"`   // After template expansion
+	ExitCode *int          `json:"exit_code,omitempty"` // Only when a process ran
+	Stderr   string        `json:"stderr,omitempty"`
+	Output   string        `json:"output"`           // Raw output before prefix and style
+	Styled   string        `json:"styled,omitempty"` // The segment as rendered, with escape sequences
+	Error    string        `json:"error,omitempty"`
+}
+
+// MarshalJSON adds the duration in milliseconds
+func (t actionTrace) MarshalJSON() ([]byte, error) {
+	type plain actionTrace
+	return json.Marshal(struct {
+		plain
+		DurationMS float64 `json:"duration_ms"`
+	}{plain(t), durationMS(t.Duration)})
+}
+
+// durationMS converts d to fractional milliseconds
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// validateTraceFormat checks a -format or -trace-format value
+func validateTraceFormat(format string) error {
+	switch format {
+	case traceFormatTable, traceFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown trace format %q (want %s or %s)", format, traceFormatTable, traceFormatJSON)
+	}
+}
+
+// writeTrace writes the traces of a render that produced output in total as a
+// table or as JSON. The table leaves the output to the caller.
+func writeTrace(w io.Writer, output string, traces []actionTrace, total time.Duration, format string) error {
+	if format == traceFormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(struct {
+			Output     string        `json:"output"`
+			DurationMS float64       `json:"duration_ms"`
+			Actions    []actionTrace `json:"actions"`
+		}{output, durationMS(total), traces})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tSTATUS\tCACHE\tDURATION\tEXIT\tCOMMAND\tSTDERR\tOUTPUT\tSTYLED")
+	for _, t := range traces {
+		exitCode := "-"
+		if t.ExitCode != nil {
+			exitCode = strconv.Itoa(*t.ExitCode)
+		}
+		status := t.Status
+		if t.Error != "" {
+			status += ": " + t.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			t.Name,
+			status,
+			orDash(t.Cache),
+			formatElapsed(t.Duration),
+			exitCode,
+			traceCell(t.Command),
+			traceCell(firstLine(t.Stderr)),
+			traceCell(t.Output),
+			// Quoted, so escape sequences neither color the table nor break its alignment
+			traceCell(strconv.Quote(t.Styled)),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "total %s\n", formatElapsed(total))
+	return err
+}
+
+// traceCell flattens s to a single line that fits in a table column
+func traceCell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" || s == `""` {
+		return "-"
+	}
+	return truncateWidth(s, traceCellWidth)
+}
+
+// firstLine returns s up to its first newline
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// formatElapsed rounds the duration of an action for display
+func formatElapsed(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
+
+// runTraceCommand implements `ccstatusline trace`
+func runTraceCommand(args []string, stdin io.Reader, fixturesDir string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("trace", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configPath := flags.String("config", "", "Path to config file")
+	fixture := flags.String("fixture", "", "Sample or recorded fixture to render")
+	format := flags.String("format", traceFormatTable, "table or json")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprint(stdout, traceUsage)
+			return nil
+		}
+		return fmt.Errorf("%w\n\n%s", err, traceUsage)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q\n\n%s", flags.Arg(0), traceUsage)
+	}
+	if err := validateTraceFormat(*format); err != nil {
+		return err
+	}
+
+	var input map[string]interface{}
+	if *fixture != "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if input, err = loadFixture(*fixture, previewSamples(cwd), fixturesDir); err != nil {
+			return err
+		}
+	} else {
+		inputJSON, err := io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		if err := json.Unmarshal(inputJSON, &input); err != nil {
+			return fmt.Errorf("failed to parse JSON: %w", err)
+		}
+	}
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	config = projectConfigFor(config, input, defaultTrustFile(), os.Stderr, nil)
+
+	processor := NewProcessor(input)
+	start := time.Now()
+	output, err := processor.Process(config)
+	if err != nil {
+		return err
+	}
+	total := time.Since(start)
+
+	if *format == traceFormatTable {
+		fmt.Fprintf(stdout, "%s\n\n", output)
+	}
+	return writeTrace(stdout, output, processor.Trace(), total, *format)
+}
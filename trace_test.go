@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessorTrace(t *testing.T) {
+	config := &Config{
+		Separator: " | ",
+		Actions: []Action{
+			{Name: "model", Template: "{.model.display_name}", Color: "cyan"},
+			{Name: "fail", Command: "echo oops >&2; echo more >&2; exit 3"},
+			{Name: "cached", Command: "echo {.model.display_name}", CacheTTL: 60},
+			{Name: "skip", Command: "echo x", When: "false"},
+			{Name: "broken", Command: "echo x", When: ".model | error"},
+		},
+	}
+	processor := NewProcessor(map[string]interface{}{"model": map[string]interface{}{"display_name": "Opus"}})
+	processor.cache = NewCache(t.TempDir())
+	processor.stderr = &bytes.Buffer{}
+
+	run := func() map[string]actionTrace {
+		t.Helper()
+		if _, err := processor.Process(config); err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+		traces := make(map[string]actionTrace)
+		for _, trace := range processor.Trace() {
+			traces[trace.Name] = trace
+		}
+		if len(traces) != len(config.Actions) {
+			t.Fatalf("Trace() has %d actions, want %d", len(traces), len(config.Actions))
+		}
+		return traces
+	}
+
+	traces := run()
+	if got := traces["model"]; got.Status != traceOK || got.Output != "Opus" || got.Styled != "\x1b[36mOpus\x1b[0m" || got.Command != "" || got.ExitCode != nil {
+		t.Errorf("model trace = %+v", got)
+	}
+	if got := traces["fail"]; got.Status != traceFailed || got.ExitCode == nil || *got.ExitCode != 3 || got.Stderr != "oops\nmore" || got.Output != "" {
+		t.Errorf("fail trace = %+v", got)
+	}
+	if got := traces["cached"]; got.Cache != traceCacheMiss || got.Command != "echo Opus" || got.ExitCode == nil || *got.ExitCode != 0 {
+		t.Errorf("cached trace = %+v", got)
+	}
+	if got := traces["skip"]; got.Status != traceSkipped {
+		t.Errorf("skip trace = %+v", got)
+	}
+	if got := traces["broken"]; got.Status != traceError || !strings.Contains(got.Error, "when") {
+		t.Errorf("broken trace = %+v", got)
+	}
+
+	// The second render is served from the cache without running a process
+	traces = run()
+	if got := traces["cached"]; got.Cache != traceCacheHit || got.Command != "" || got.ExitCode != nil || got.Output != "Opus" {
+		t.Errorf("cached trace on the second run = %+v", got)
+	}
+}
+
+func TestTraceCommand(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := "actions:\n  - name: model\n    template: \"{.model.display_name}\"\n  - name: fail\n    command: \"echo oops >&2; exit 3\"\n"
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	input := `{"model": {"display_name": "Opus"}}`
+
+	t.Run("table", func(t *testing.T) {
+		var stdout bytes.Buffer
+		if err := runTraceCommand([]string{"-config", configPath}, strings.NewReader(input), t.TempDir(), &stdout); err != nil {
+			t.Fatalf("runTraceCommand() error = %v", err)
+		}
+		lines := strings.Split(stdout.String(), "\n")
+		if lines[0] != "Opus" || lines[1] != "" {
+			t.Errorf("statusline = %q", lines[:2])
+		}
+		if fields := strings.Fields(lines[2]); strings.Join(fields, " ") != "ACTION STATUS CACHE DURATION EXIT COMMAND STDERR OUTPUT STYLED" {
+			t.Errorf("header = %q", lines[2])
+		}
+		if fields := strings.Fields(lines[4]); fields[0] != "fail" || fields[1] != "failed" || fields[4] != "3" || !strings.Contains(lines[4], "oops") {
+			t.Errorf("fail row = %q", lines[4])
+		}
+		if !strings.HasPrefix(lines[5], "total ") {
+			t.Errorf("last line = %q, want the total duration", lines[5])
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var stdout bytes.Buffer
+		if err := runTraceCommand([]string{"-config", configPath, "-format", "json"}, strings.NewReader(input), t.TempDir(), &stdout); err != nil {
+			t.Fatalf("runTraceCommand() error = %v", err)
+		}
+		var report struct {
+			Output  string `json:"output"`
+			Actions []struct {
+				Name     string `json:"name"`
+				Status   string `json:"status"`
+				ExitCode *int   `json:"exit_code"`
+				Stderr   string `json:"stderr"`
+			} `json:"actions"`
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+			t.Fatalf("output is not JSON: %v\n%s", err, stdout.String())
+		}
+		if report.Output != "Opus" || len(report.Actions) != 2 {
+			t.Fatalf("report = %+v", report)
+		}
+		if got := report.Actions[1]; got.Name != "fail" || got.Status != traceFailed || got.ExitCode == nil || *got.ExitCode != 3 || got.Stderr != "oops" {
+			t.Errorf("fail = %+v", got)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if err := runTraceCommand([]string{"-config", configPath, "-format", "xml"}, strings.NewReader(input), t.TempDir(), &bytes.Buffer{}); err == nil {
+			t.Error("runTraceCommand() should fail for an unknown format")
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// transcriptDir is the cache subdirectory holding transcript parse states
+const transcriptDir = "transcript"
+
+// Context window sizes assumed when context_window is not configured
+const (
+	defaultContextWindow = 200000
+	longContextWindow    = 1000000 // Models with a [1m] suffix in model.id
+)
+
+// tokenUsage counts the tokens of assistant messages by kind
+type tokenUsage struct {
+	Input         int64 `json:"input"`
+	Output        int64 `json:"output"`
+	CacheRead     int64 `json:"cache_read"`
+	CacheCreation int64 `json:"cache_creation"`
+}
+
+// add adds the counts of other to u
+func (u *tokenUsage) add(other tokenUsage) {
+	u.Input += other.Input
+	u.Output += other.Output
+	u.CacheRead += other.CacheRead
+	u.CacheCreation += other.CacheCreation
+}
+
+// transcriptState is the result of parsing a transcript up to Offset. It is
+// kept in the cache so that a render only parses the lines appended since the
+// previous one.
+type transcriptState struct {
+	Path          string                `json:"path"`
+	Offset        int64                 `json:"offset"`         // Bytes of complete lines parsed so far
+	Usage         tokenUsage            `json:"usage"`          // Totals over all assistant messages
+	Models        map[string]tokenUsage `json:"models"`         // Totals per model, for cost estimation
+	ContextTokens int64                 `json:"context_tokens"` // Input side of the latest main-chain message
+	LastMessageID string                `json:"last_message_id"`
+}
+
+// transcriptLine is the part of a transcript entry that carries token usage
+type transcriptLine struct {
+	Type        string `json:"type"`
+	IsSidechain bool   `json:"isSidechain"`
+	Message     struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+		Usage *struct {
+			InputTokens              int64 `json:"input_tokens"`
+			OutputTokens             int64 `json:"output_tokens"`
+			CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+			CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+}
+
+// ParseTranscript returns the token usage recorded in the transcript at path,
+// parsing only what was appended since the cached state. A transcript that
+// shrank (e.g. was rewritten) is parsed again from the start.
+func (c *Cache) ParseTranscript(path string) (transcriptState, error) {
+	statePath := c.transcriptStatePath(path)
+	state := transcriptState{Path: path}
+	if data, err := os.ReadFile(statePath); err == nil {
+		var cached transcriptState
+		if json.Unmarshal(data, &cached) == nil && cached.Path == path {
+			state = cached
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return transcriptState{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return transcriptState{}, err
+	}
+	if info.Size() < state.Offset {
+		state = transcriptState{Path: path}
+	}
+	if info.Size() == state.Offset {
+		return state, nil
+	}
+
+	if _, err := f.Seek(state.Offset, io.SeekStart); err != nil {
+		return transcriptState{}, err
+	}
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A partial last line is still being written; parse it next time
+			break
+		}
+		if err != nil {
+			return transcriptState{}, err
+		}
+		state.Offset += int64(len(line))
+		state.addLine(line)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return state, err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return state, err
+	}
+	return state, writeFileAtomic(statePath, data)
+}
+
+// addLine adds the usage of one transcript line to the state
+func (s *transcriptState) addLine(line []byte) {
+	// Most lines are user messages and tool results; skip them without decoding
+	if !bytes.Contains(line, []byte(`"usage"`)) {
+		return
+	}
+
+	var entry transcriptLine
+	if err := json.Unmarshal(line, &entry); err != nil || entry.Type != "assistant" || entry.Message.Usage == nil {
+		return
+	}
+
+	// A message with several content blocks is written once per block, each
+	// with the same usage
+	if entry.Message.ID != "" {
+		if entry.Message.ID == s.LastMessageID {
+			return
+		}
+		s.LastMessageID = entry.Message.ID
+	}
+
+	usage := tokenUsage{
+		Input:         entry.Message.Usage.InputTokens,
+		Output:        entry.Message.Usage.OutputTokens,
+		CacheRead:     entry.Message.Usage.CacheReadInputTokens,
+		CacheCreation: entry.Message.Usage.CacheCreationInputTokens,
+	}
+	s.Usage.add(usage)
+
+	if entry.Message.Model != "" {
+		if s.Models == nil {
+			s.Models = make(map[string]tokenUsage)
+		}
+		modelUsage := s.Models[entry.Message.Model]
+		modelUsage.add(usage)
+		s.Models[entry.Message.Model] = modelUsage
+	}
+
+	// Subagent messages have their own context; synthetic messages have no usage
+	if context := usage.Input + usage.CacheRead + usage.CacheCreation; !entry.IsSidechain && context > 0 {
+		s.ContextTokens = context
+	}
+}
+
+// transcriptStatePath returns where the parse state of a transcript is cached
+func (c *Cache) transcriptStatePath(path string) string {
+	hash := sha256.Sum256([]byte(path))
+	return filepath.Join(c.dir, transcriptDir, hex.EncodeToString(hash[:])+".json")
+}
+
+// pruneTranscripts removes parse states of transcripts that no longer exist
+// or haven't grown within the retention period, returning how many were removed
+func (c *Cache) pruneTranscripts() int {
+	dir := filepath.Join(c.dir, transcriptDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	removed := 0
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		info, err := file.Info()
+		if err != nil || file.IsDir() {
+			continue
+		}
+
+		remove := time.Since(info.ModTime()) > staleRetention
+		if data, err := os.ReadFile(path); err == nil {
+			var state transcriptState
+			if json.Unmarshal(data, &state) == nil && state.Path != "" {
+				if _, err := os.Stat(state.Path); os.IsNotExist(err) {
+					remove = true
+				}
+			}
+		}
+		if remove && os.Remove(path) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// contextWindow returns the context window size for a model
+func contextWindow(configured int, modelID string) int {
+	if configured > 0 {
+		return configured
+	}
+	if strings.HasSuffix(strings.ToLower(modelID), "[1m]") {
+		return longContextWindow
+	}
+	return defaultContextWindow
+}
+
+// transcriptFields returns the template fields computed from a transcript state,
+// exposed as .ccstatusline.tokens
+func transcriptFields(state transcriptState, window int) map[string]interface{} {
+	percent := 0.0
+	if window > 0 {
+		percent = math.Round(float64(state.ContextTokens)*1000/float64(window)) / 10
+	}
+
+	return map[string]interface{}{
+		"input":           state.Usage.Input,
+		"output":          state.Usage.Output,
+		"cache_read":      state.Usage.CacheRead,
+		"cache_creation":  state.Usage.CacheCreation,
+		"total":           state.Usage.Input + state.Usage.Output + state.Usage.CacheRead + state.Usage.CacheCreation,
+		"context":         state.ContextTokens,
+		"context_window":  window,
+		"context_percent": percent,
+	}
+}
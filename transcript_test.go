@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// assistantLine returns a transcript line of an assistant message with usage
+func assistantLine(id, model string, sidechain bool, input, output, cacheRead, cacheCreation int) string {
+	return fmt.Sprintf(`{"type":"assistant","isSidechain":%t,"message":{"id":%q,"model":%q,"usage":{"input_tokens":%d,"output_tokens":%d,"cache_read_input_tokens":%d,"cache_creation_input_tokens":%d}}}`+"\n",
+		sidechain, id, model, input, output, cacheRead, cacheCreation)
+}
+
+// appendFile appends content to the file at path
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTranscript(t *testing.T) {
+	cache := NewCache(t.TempDir())
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+
+	appendFile(t, path, `{"type":"user","message":{"role":"user","content":"hi"}}`+"\n"+
+		assistantLine("msg_1", "claude-sonnet-4", false, 10, 5, 100, 20)+
+		// Same message written again for its second content block
+		assistantLine("msg_1", "claude-sonnet-4", false, 10, 5, 100, 20)+
+		// Subagent messages count towards usage but not the main context
+		assistantLine("msg_2", "claude-haiku", true, 1000, 50, 0, 0)+
+		"not json\n")
+
+	state, err := cache.ParseTranscript(path)
+	if err != nil {
+		t.Fatalf("ParseTranscript() error = %v", err)
+	}
+	want := tokenUsage{Input: 1010, Output: 55, CacheRead: 100, CacheCreation: 20}
+	if state.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", state.Usage, want)
+	}
+	if state.ContextTokens != 130 {
+		t.Errorf("ContextTokens = %d, want 130", state.ContextTokens)
+	}
+	if got := state.Models["claude-haiku"]; got.Input != 1000 {
+		t.Errorf("Models[claude-haiku] = %+v", got)
+	}
+
+	// 追記分だけがパースされ、書き込み途中の行は次回に回される
+	appendFile(t, path, assistantLine("msg_3", "claude-sonnet-4", false, 1, 2, 300, 0)+`{"type":"assistant","mess`)
+	state, err = cache.ParseTranscript(path)
+	if err != nil {
+		t.Fatalf("ParseTranscript() error = %v", err)
+	}
+	if state.Usage.Output != 57 || state.ContextTokens != 301 {
+		t.Errorf("after append: Usage = %+v, ContextTokens = %d", state.Usage, state.ContextTokens)
+	}
+	info, _ := os.Stat(path)
+	if state.Offset >= info.Size() {
+		t.Errorf("Offset = %d, want the partial line (file size %d) left unparsed", state.Offset, info.Size())
+	}
+
+	// キャッシュされた状態から続きを読む(既存の行を二重に数えない)
+	state, err = NewCache(cache.dir).ParseTranscript(path)
+	if err != nil {
+		t.Fatalf("ParseTranscript() error = %v", err)
+	}
+	if state.Usage.Output != 57 {
+		t.Errorf("reparse from cached state: Output = %d, want 57", state.Usage.Output)
+	}
+
+	// 書き換えられて短くなったトランスクリプトは最初から読み直す
+	if err := os.WriteFile(path, []byte(assistantLine("msg_9", "claude-opus-4", false, 7, 8, 0, 0)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state, err = cache.ParseTranscript(path)
+	if err != nil {
+		t.Fatalf("ParseTranscript() error = %v", err)
+	}
+	if state.Usage != (tokenUsage{Input: 7, Output: 8}) {
+		t.Errorf("after rewrite: Usage = %+v", state.Usage)
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		configured int
+		modelID    string
+		expected   int
+	}{
+		{configured: 0, modelID: "claude-sonnet-4", expected: defaultContextWindow},
+		{configured: 0, modelID: "claude-sonnet-4[1m]", expected: longContextWindow},
+		{configured: 500000, modelID: "claude-sonnet-4", expected: 500000},
+	}
+
+	for _, tt := range tests {
+		if got := contextWindow(tt.configured, tt.modelID); got != tt.expected {
+			t.Errorf("contextWindow(%d, %q) = %d, want %d", tt.configured, tt.modelID, got, tt.expected)
+		}
+	}
+}
+
+func TestProcessorTranscriptFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	appendFile(t, path, assistantLine("msg_1", "claude-sonnet-4", false, 1000, 500, 40000, 9000))
+
+	config := &Config{
+		Actions: []Action{
+			{Name: "context", Template: "{.ccstatusline.tokens.context_percent}%"},
+			// Commands see the fields in the JSON on stdin, too
+			{Name: "stdin", Command: "grep -c '\"context_percent\":25'"},
+		},
+		Separator: " | ",
+	}
+
+	processor := NewProcessor(map[string]interface{}{"transcript_path": path})
+	processor.cache = NewCache(t.TempDir())
+	result, err := processor.Process(config)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if result != "25% | 1" {
+		t.Errorf("Process() = %q, want %q", result, "25% | 1")
+	}
+}
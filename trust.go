@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const trustUsage = `Usage: ccstatusline trust [options] [PATH]
+
+Trusts a project config (.ccstatusline.yaml) so that its actions are run.
+A project config is only used while its content matches the hash recorded
+here, so a config that arrives with a clone, or changes with a pull, is
+ignored until it has been reviewed and trusted again. PATH defaults to the
+nearest .ccstatusline.yaml above the current directory.
+
+Options:
+  -list      List trusted project configs and whether they changed since
+  -revoke    Stop trusting PATH
+`
+
+// trustFileName is the allowlist of project configs in the data directory,
+// one "<sha256>  <path>" line per config like the output of sha256sum
+const trustFileName = "trusted"
+
+// defaultTrustFile returns the path of the allowlist of project configs
+func defaultTrustFile() string {
+	return filepath.Join(defaultDataDir(), trustFileName)
+}
+
+// hashConfig returns the hex SHA-256 of a project config's content
+func hashConfig(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalPath makes path absolute and resolves symlinks, so a config is
+// trusted once however it is reached
+func canonicalPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// readTrusted returns the trusted hash of each project config path. A
+// missing allowlist trusts nothing.
+func readTrusted(trustFile string) (map[string]string, error) {
+	f, err := os.Open(trustFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	trusted := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, path, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok || hash == "" || path == "" {
+			continue
+		}
+		trusted[path] = hash
+	}
+	return trusted, scanner.Err()
+}
+
+// writeTrusted replaces the allowlist with trusted
+func writeTrusted(trustFile string, trusted map[string]string) error {
+	paths := make([]string, 0, len(trusted))
+	for path := range trusted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s  %s\n", trusted[path], path)
+	}
+	if err := os.MkdirAll(filepath.Dir(trustFile), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(trustFile, []byte(b.String()))
+}
+
+// isTrusted reports whether the project config at path has been trusted with
+// exactly the content data
+func isTrusted(trustFile, path string, data []byte) (bool, error) {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return false, err
+	}
+	trusted, err := readTrusted(trustFile)
+	if err != nil {
+		return false, err
+	}
+	return trusted[canonical] == hashConfig(data), nil
+}
+
+// trustProjectConfig records the current content of the project config at path as trusted
+func trustProjectConfig(trustFile, path string, data []byte) error {
+	canonical, err := canonicalPath(path)
+	if err != nil {
+		return err
+	}
+	trusted, err := readTrusted(trustFile)
+	if err != nil {
+		return err
+	}
+	trusted[canonical] = hashConfig(data)
+	return writeTrusted(trustFile, trusted)
+}
+
+// revokeProjectConfig removes the project config at path from the allowlist.
+// The path is matched as given too, so a deleted config can still be revoked.
+func revokeProjectConfig(trustFile, path string) error {
+	trusted, err := readTrusted(trustFile)
+	if err != nil {
+		return err
+	}
+	candidates := []string{path}
+	if abs, err := filepath.Abs(path); err == nil {
+		candidates = append(candidates, abs)
+	}
+	if canonical, err := canonicalPath(path); err == nil {
+		candidates = append(candidates, canonical)
+	}
+
+	found := false
+	for _, candidate := range candidates {
+		if _, ok := trusted[candidate]; ok {
+			delete(trusted, candidate)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s is not trusted", path)
+	}
+	return writeTrusted(trustFile, trusted)
+}
+
+// runTrustCommand implements `ccstatusline trust`
+func runTrustCommand(args []string, trustFile string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("trust", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	list := flags.Bool("list", false, "List trusted project configs")
+	revoke := flags.Bool("revoke", false, "Stop trusting the project config")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprint(stdout, trustUsage)
+			return nil
+		}
+		return fmt.Errorf("%w\n\n%s", err, trustUsage)
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("unexpected argument %q\n\n%s", flags.Arg(1), trustUsage)
+	}
+
+	if *list {
+		if flags.NArg() > 0 {
+			return fmt.Errorf("unexpected argument %q\n\n%s", flags.Arg(0), trustUsage)
+		}
+		return trustList(trustFile, stdout)
+	}
+
+	path := flags.Arg(0)
+	if path == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if path = findProjectConfigFrom(cwd); path == "" {
+			return fmt.Errorf("no %s found in %s or its parents", projectConfigName, cwd)
+		}
+	}
+
+	if *revoke {
+		if err := revokeProjectConfig(trustFile, path); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Revoked %s\n", path)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	overlay, err := parseProjectConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	commands := make([]string, len(overlay))
+	for i, entry := range overlay {
+		if entry.mode == overlayDisable {
+			continue
+		}
+		var action Action
+		if err := entry.decodeInto(&action); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		commands[i] = action.Command
+	}
+
+	// Show what is about to be allowed to run
+	fmt.Fprintf(stdout, "%s:\n", path)
+	for i, entry := range overlay {
+		mode := entry.mode
+		if mode == "" {
+			mode = "merge or append"
+		}
+		fmt.Fprintf(stdout, "  %s (%s)\n", entry.name, mode)
+		if commands[i] != "" {
+			fmt.Fprintf(stdout, "    command: %s\n", commands[i])
+		}
+	}
+
+	if err := trustProjectConfig(trustFile, path, data); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Trusted %s\n", path)
+	return nil
+}
+
+// trustList prints the trusted project configs and whether each still
+// matches its trusted content
+func trustList(trustFile string, stdout io.Writer) error {
+	trusted, err := readTrusted(trustFile)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(trusted))
+	for path := range trusted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		state := "trusted"
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			state = "missing"
+		case err != nil:
+			state = "unreadable"
+		case hashConfig(data) != trusted[path]:
+			state = "changed"
+		}
+		fmt.Fprintf(stdout, "%-10s %s\n", state, path)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrustCommand(t *testing.T) {
+	repo := t.TempDir()
+	trustFile := filepath.Join(t.TempDir(), "trusted")
+	projectConfig := filepath.Join(repo, projectConfigName)
+	content := "actions:\n  - name: kube\n    command: kubectl config current-context\n  - name: cost\n    mode: disable\n"
+	if err := os.WriteFile(projectConfig, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := canonicalPath(projectConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, error) {
+		var stdout bytes.Buffer
+		err := runTrustCommand(args, trustFile, &stdout)
+		return stdout.String(), err
+	}
+
+	output, err := run(projectConfig)
+	if err != nil {
+		t.Fatalf("trust error = %v", err)
+	}
+	for _, want := range []string{"kube (merge or append)", "command: kubectl config current-context", "cost (disable)", "Trusted " + projectConfig} {
+		if !strings.Contains(output, want) {
+			t.Errorf("trust output = %q, want it to contain %q", output, want)
+		}
+	}
+
+	if output, _ := run("-list"); output != "trusted    "+canonical+"\n" {
+		t.Errorf("list output = %q", output)
+	}
+	if err := os.WriteFile(projectConfig, []byte(content+"# edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := run("-list"); output != "changed    "+canonical+"\n" {
+		t.Errorf("list output after change = %q", output)
+	}
+
+	if _, err := run("-revoke", projectConfig); err != nil {
+		t.Fatalf("revoke error = %v", err)
+	}
+	if output, _ := run("-list"); output != "" {
+		t.Errorf("list output after revoke = %q", output)
+	}
+	if _, err := run("-revoke", projectConfig); err == nil {
+		t.Error("revoking an untrusted config should fail")
+	}
+
+	// An invalid config is not trusted
+	if err := os.WriteFile(projectConfig, []byte("actions:\n  - name: kube\n    colour: red\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(projectConfig); err == nil {
+		t.Error("trusting an invalid config should fail")
+	}
+	if output, _ := run("-list"); output != "" {
+		t.Errorf("list output after failed trust = %q", output)
+	}
+}
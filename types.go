@@ -1,16 +1,77 @@
 package main
 
+import "time"
+
 // Config represents the configuration structure
 type Config struct {
+	Actions       []Action      `yaml:"actions"`
+	Lines         []Line        `yaml:"lines"` // Multi-line form, replaces actions
+	Separator     string        `yaml:"separator"`
+	Concurrency   int           `yaml:"concurrency"`    // Max actions run at once (0 or unset = no limit)
+	Timeout       time.Duration `yaml:"timeout"`        // Deadline for the whole run, e.g. "2s" (0 or unset = none)
+	StrictQuoting bool          `yaml:"strict_quoting"` // Reject {.field} in commands unless shell-quoted as {@sh .field}
+	ColorDepth    string        `yaml:"color_depth"`    // auto (default, from COLORTERM/TERM), truecolor, 256 or 16
+	Layout        string        `yaml:"layout"`         // plain (default, joined by separator) or powerline
+	Powerline     Powerline     `yaml:"powerline"`      // Glyphs used by the powerline layout
+	MaxWidth      int           `yaml:"max_width"`      // Cells the line must fit in (0 or unset = COLUMNS if set, else no limit)
+	ContextWindow int           `yaml:"context_window"` // Tokens in the model's context window (0 or unset = by model.id)
+	Cost          CostConfig    `yaml:"cost"`           // Session cost estimation
+	LogLevel      string        `yaml:"log_level"`      // debug, info, warn (default), error or off; CCSTATUSLINE_LOG overrides it
+
+	path string // File the config was loaded from, passed on to background refreshes
+}
+
+// Line is one line of a multi-line statusline
+type Line struct {
 	Actions   []Action `yaml:"actions"`
-	Separator string   `yaml:"separator"`
+	Separator string   `yaml:"separator"` // Separator between segments (default: the top-level separator)
+	Align     string   `yaml:"align"`     // left (default), right or center
+}
+
+// CostConfig configures the session cost estimate exposed as .ccstatusline.cost
+type CostConfig struct {
+	Format  string                `yaml:"format"`  // printf format of the formatted cost (default: "$%.2f")
+	Rate    float64               `yaml:"rate"`    // Multiplier from USD to the displayed currency (default: 1)
+	Pricing map[string]ModelPrice `yaml:"pricing"` // Prices by model id prefix, overriding the built-in table
+}
+
+// Powerline holds the glyphs of the powerline layout
+type Powerline struct {
+	Separator     string `yaml:"separator"`      // Between segments with different backgrounds (default: "\ue0b0")
+	ThinSeparator string `yaml:"thin_separator"` // Between segments with the same background (default: "\ue0b1")
+	LeftCap       string `yaml:"left_cap"`       // Before the first segment, e.g. "\ue0b6" (default: none)
+	RightCap      string `yaml:"right_cap"`      // After the last segment, e.g. "\ue0b0" (default: none)
 }
 
 // Action represents a single action in the configuration
 type Action struct {
-	Name     string `yaml:"name"`      // Required: unique identifier for action
-	Command  string `yaml:"command"`   // Shell command to execute or template text
-	Prefix   string `yaml:"prefix"`    // Optional prefix to prepend to command output
-	Color    string `yaml:"color"`     // Optional color (foreground or background with bg_ prefix)
-	CacheTTL int    `yaml:"cache_ttl"` // Cache TTL in seconds (0 or unset = no cache)
+	Name        string        `yaml:"name"`        // Required: unique identifier for action
+	Command     string        `yaml:"command"`     // Shell command to execute (templates are expanded first)
+	Template    string        `yaml:"template"`    // Text rendered from {.field} templates only, never run by a shell
+	Prefix      string        `yaml:"prefix"`      // Optional prefix to prepend to command output
+	Color       string        `yaml:"color"`       // Optional color: name, #rrggbb, rgb(r,g,b) or 256:N (bg_ prefix for background)
+	Style       StyleSpec     `yaml:"style"`       // Optional style spec, e.g. "bold fg:white bg:red", or a mapping
+	CacheTTL    int           `yaml:"cache_ttl"`   // Cache TTL in seconds (0 or unset = no cache)
+	Timeout     time.Duration `yaml:"timeout"`     // Command deadline, e.g. "500ms" (0 or unset = none)
+	Placeholder string        `yaml:"placeholder"` // Shown on timeout when no cached value is available
+	StaleTTL    int           `yaml:"stale_ttl"`   // Seconds an expired value is still served while refreshing in background
+	CacheScope  string        `yaml:"cache_scope"` // Who shares the cached value: global, cwd (default), project_dir, session_id, git_head
+	CacheKey    string        `yaml:"cache_key"`   // Template for a custom cache key, overrides cache_scope
+	When        string        `yaml:"when"`        // jq condition on the input JSON; the action is skipped when falsy
+	DependsOn   []string      `yaml:"depends_on"`  // Actions whose outputs this action uses via $actions.<name>
+	ColorRules  []ColorRule   `yaml:"color_rules"` // Styles picked by jq conditions on the output; color is the fallback
+	MaxWidth    int           `yaml:"max_width"`   // Cells the segment is truncated to, with an ellipsis (0 or unset = no limit)
+	Priority    int           `yaml:"priority"`    // Segments with lower priority are dropped first when the line is too wide
+	OnError     string        `yaml:"on_error"`    // What a failed command shows: hide (default), placeholder, stderr or cached
+	ErrorStyle  StyleSpec     `yaml:"error_style"` // Style of the stderr line shown by on_error: stderr (default: red)
 }
+
+// ColorRule styles an action's output when its condition holds
+type ColorRule struct {
+	When  string    `yaml:"when"`  // jq condition; . is the output (a number when it parses as one)
+	Style StyleSpec `yaml:"style"` // Style spec used when the condition holds
+}
+
+// StyleSpec is a style spec string such as "bold fg:white bg:red".
+// In YAML it may also be written as a mapping with fg, bg, bold, dim, italic and underline keys.
+type StyleSpec string
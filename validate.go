@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"gopkg.in/yaml.v3"
+)
+
+const validateUsage = `Usage: ccstatusline validate [-config PATH]
+
+Checks the config file (the default location when -config is not given) and
+reports every problem as file:line:column: message. Besides the checks done
+when the statusline runs, unknown keys are reported and every {...} template
+in command, template and cache_key is parsed as a jq expression.
+`
+
+// diagnostic is a problem found by `ccstatusline validate`
+type diagnostic struct {
+	file    string
+	line    int // 1-based; 0 when unknown
+	column  int // 1-based; 0 when unknown
+	message string
+}
+
+func (d diagnostic) String() string {
+	switch {
+	case d.line == 0:
+		return fmt.Sprintf("%s: %s", d.file, d.message)
+	case d.column == 0:
+		return fmt.Sprintf("%s:%d: %s", d.file, d.line, d.message)
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", d.file, d.line, d.column, d.message)
+	}
+}
+
+// runValidateCommand implements `ccstatusline validate`
+func runValidateCommand(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configPath := flags.String("config", "", "Path to config file")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprint(stdout, validateUsage)
+			return nil
+		}
+		return fmt.Errorf("%w\n\n%s", err, validateUsage)
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q\n\n%s", flags.Arg(0), validateUsage)
+	}
+
+	path := resolveConfigPath(*configPath)
+	diagnostics, err := validateConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintln(stdout, d)
+	}
+	if len(diagnostics) > 0 {
+		return fmt.Errorf("%s: %d problem(s) found", path, len(diagnostics))
+	}
+	fmt.Fprintf(stdout, "%s: OK\n", path)
+	return nil
+}
+
+// validateConfigFile returns every problem of the config file at path, ordered by position
+func validateConfigFile(path string) ([]diagnostic, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// The node tree gives positions; syntax errors stop here
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return []diagnostic{yamlDiagnostic(path, nil, strings.TrimPrefix(err.Error(), "yaml: "))}, nil
+	}
+	root := &document
+	if document.Kind == yaml.DocumentNode && len(document.Content) > 0 {
+		root = document.Content[0]
+	}
+
+	var diagnostics []diagnostic
+	config, err := decodeConfig(data)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		// Unknown keys and mistyped values; everything else was still decoded
+		for _, message := range typeErr.Errors {
+			diagnostics = append(diagnostics, yamlDiagnostic(path, root, message))
+		}
+	} else if err != nil {
+		return []diagnostic{yamlDiagnostic(path, nil, strings.TrimPrefix(err.Error(), "yaml: "))}, nil
+	}
+
+	actions := actionNodes(root)
+	problems := append(checkConfig(config), templateProblems(config.allActions())...)
+	for _, problem := range problems {
+		node := root
+		if problem.action >= 0 && problem.action < len(actions) {
+			node = actions[problem.action]
+		}
+		node = nodeAt(node, problem.path)
+		diagnostics = append(diagnostics, diagnostic{file: path, line: node.Line, column: node.Column, message: problem.err.Error()})
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].line != diagnostics[j].line {
+			return diagnostics[i].line < diagnostics[j].line
+		}
+		return diagnostics[i].column < diagnostics[j].column
+	})
+	return diagnostics, nil
+}
+
+// templateProblems parses every {...} placeholder of the templated fields as a jq
+// expression. A broken placeholder only shows up as [ERROR: ...] in the statusline,
+// so it is reported by validate but not rejected by LoadConfig.
+func templateProblems(actions []Action) []configProblem {
+	var problems []configProblem
+	for i, action := range actions {
+		for _, field := range []struct {
+			path  string
+			value string
+		}{{"command", action.Command}, {"template", action.Template}, {"cache_key", action.CacheKey}} {
+			for _, match := range templatePattern.FindAllStringSubmatch(field.value, -1) {
+				content := strings.TrimSpace(match[1])
+				if _, err := gojq.Parse(placeholderQuery(content)); err != nil {
+					problems = append(problems, configProblem{
+						action: i,
+						path:   field.path,
+						err:    fmt.Errorf("action %s: %s: invalid template %s: %w", actionLabel(i, action), field.path, match[0], err),
+					})
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// yamlLinePattern matches the "line N: " prefix of yaml.v3 error messages
+var yamlLinePattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// unknownFieldPattern matches the error KnownFields gives for an unknown key
+var unknownFieldPattern = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+
+// yamlDiagnostic turns a yaml.v3 error message into a diagnostic. yaml.v3 only
+// reports lines, so the column is looked up in the node tree when there is one.
+func yamlDiagnostic(path string, root *yaml.Node, message string) diagnostic {
+	d := diagnostic{file: path, message: message}
+	m := yamlLinePattern.FindStringSubmatch(message)
+	if m == nil {
+		return d
+	}
+	d.line, _ = strconv.Atoi(m[1])
+	d.message = m[2]
+
+	key := ""
+	if field := unknownFieldPattern.FindStringSubmatch(m[2]); field != nil {
+		key = field[1]
+		d.message = fmt.Sprintf("unknown key %s", key)
+	}
+	if root != nil {
+		d.column = columnOnLine(root, d.line, key)
+	}
+	return d
+}
+
+// columnOnLine returns the column of the first node on line, or of the mapping
+// key named key when it is set. It returns 0 when there is no such node.
+func columnOnLine(node *yaml.Node, line int, key string) int {
+	column := 0
+	var walk func(node *yaml.Node, isKey bool)
+	walk = func(node *yaml.Node, isKey bool) {
+		if node.Line == line && (key == "" || isKey && node.Value == key) {
+			if column == 0 || node.Column < column {
+				column = node.Column
+			}
+		}
+		for i, child := range node.Content {
+			walk(child, node.Kind == yaml.MappingNode && i%2 == 0)
+		}
+	}
+	walk(node, false)
+	return column
+}
+
+// actionNodes returns the mapping nodes of the actions in the order of allActions()
+func actionNodes(root *yaml.Node) []*yaml.Node {
+	var nodes []*yaml.Node
+	if lines := nodeAt(root, "lines"); lines != root && lines.Kind == yaml.SequenceNode && len(lines.Content) > 0 {
+		for _, line := range lines.Content {
+			if actions := nodeAt(line, "actions"); actions != line && actions.Kind == yaml.SequenceNode {
+				nodes = append(nodes, actions.Content...)
+			}
+		}
+		return nodes
+	}
+	if actions := nodeAt(root, "actions"); actions != root && actions.Kind == yaml.SequenceNode {
+		nodes = actions.Content
+	}
+	return nodes
+}
+
+// nodeAt follows a dotted path of mapping keys and sequence indexes from node
+// to a value. It stops at the deepest node it can reach, so a missing key is
+// reported at the mapping that lacks it.
+func nodeAt(node *yaml.Node, path string) *yaml.Node {
+	if path == "" {
+		return node
+	}
+	for _, part := range strings.Split(path, ".") {
+		for node.Kind == yaml.AliasNode && node.Alias != nil {
+			node = node.Alias
+		}
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == part {
+					next = node.Content[i+1]
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(part); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+	return node
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []string // Lines of output, with the config path as "CONFIG"
+		wantErr  bool
+	}{
+		{
+			name:     "valid",
+			content:  "actions:\n  - name: model\n    command: \"echo {@sh .model.display_name}\"\n    color: cyan\n",
+			expected: []string{"CONFIG: OK"},
+		},
+		{
+			name: "all problems with positions",
+			content: `colour_depth: 256
+actions:
+  - name: model
+    command: "echo {.model.display_name}"
+    color: purple
+    cache-ttl: 5
+  - name: dir
+    template: "{.cwd | split(\"/\") | .[-1}"
+    style: {fg: red, blink: true}
+  - command: "echo hi"
+    depends_on: [nope]
+    color_rules:
+      - when: ". >"
+        style: bold
+`,
+			expected: []string{
+				"CONFIG:1:1: unknown key colour_depth",
+				`CONFIG:5:12: action model: unknown color "purple"`,
+				"CONFIG:6:5: unknown key cache-ttl",
+				`CONFIG:8:15: action dir: template: invalid template {.cwd | split("/") | .[-1}: unexpected EOF`,
+				"CONFIG:9:22: unknown key blink",
+				"CONFIG:10:5: action at index 2: name is required",
+				"CONFIG:11:18: action at index 2: depends_on unknown action nope",
+				"CONFIG:13:15: action at index 2: color_rules[0]: invalid when expression: unexpected EOF",
+			},
+			wantErr: true,
+		},
+		{
+			name: "lines",
+			content: `lines:
+  - actions:
+      - name: a
+        template: a
+  - align: middle
+    actions:
+      - name: a
+        template: b
+`,
+			expected: []string{
+				`CONFIG:5:12: line 2: unknown align "middle" (want left, right or center)`,
+				"CONFIG:7:15: duplicate action name: a",
+			},
+			wantErr: true,
+		},
+		{
+			name:     "syntax error",
+			content:  "actions:\n  - name: a\n   template: a\n",
+			expected: []string{"CONFIG:1: did not find expected '-' indicator"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout bytes.Buffer
+			err := runValidateCommand([]string{"-config", configPath}, &stdout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runValidateCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			expected := strings.ReplaceAll(strings.Join(tt.expected, "\n")+"\n", "CONFIG", configPath)
+			if got := stdout.String(); got != expected {
+				t.Errorf("output =\n%s\nwant\n%s", got, expected)
+			}
+		})
+	}
+}
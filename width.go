@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ellipsis marks text cut off by truncateWidth
+const ellipsis = "…"
+
+// ansiPattern matches CSI sequences (colors etc.) and OSC sequences (e.g. hyperlinks)
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;:?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+// wideRanges lists the East Asian wide and fullwidth ranges and the emoji
+// presented as wide, which take two cells in a terminal
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x231A, 0x231B}, {0x2329, 0x232A}, {0x23E9, 0x23EC},
+	{0x23F0, 0x23F0}, {0x23F3, 0x23F3}, {0x25FD, 0x25FE}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267F, 0x267F}, {0x2693, 0x2693}, {0x26A1, 0x26A1},
+	{0x26AA, 0x26AB}, {0x26BD, 0x26BE}, {0x26C4, 0x26C5}, {0x26CE, 0x26CE},
+	{0x26D4, 0x26D4}, {0x26EA, 0x26EA}, {0x26F2, 0x26F3}, {0x26F5, 0x26F5},
+	{0x26FA, 0x26FA}, {0x26FD, 0x26FD}, {0x2705, 0x2705}, {0x270A, 0x270B},
+	{0x2728, 0x2728}, {0x274C, 0x274C}, {0x274E, 0x274E}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27B0, 0x27B0}, {0x27BF, 0x27BF},
+	{0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55}, {0x2E80, 0x303E},
+	{0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF}, {0xA000, 0xA4CF},
+	{0xA960, 0xA97F}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE10, 0xFE19},
+	{0xFE30, 0xFE6F}, {0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x1F004, 0x1F004},
+	{0x1F0CF, 0x1F0CF}, {0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}, {0x1F200, 0x1F251},
+	{0x1F300, 0x1F64F}, {0x1F680, 0x1F6FF}, {0x1F7E0, 0x1F7EB}, {0x1F90C, 0x1F9FF},
+	{0x1FA70, 0x1FAFF}, {0x20000, 0x2FFFD}, {0x30000, 0x3FFFD},
+}
+
+// runeWidth returns the number of terminal cells r occupies
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		// Control characters
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		// Combining marks, variation selectors, zero-width joiners
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r < wide[0] {
+			break
+		}
+		if r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// displayWidth returns the number of terminal cells s occupies, ignoring escape sequences
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range ansiPattern.ReplaceAllString(s, "") {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// truncateWidth cuts s to at most maxWidth cells, ending it with an ellipsis
+// when anything was cut. Escape sequences are kept and don't count; if any
+// were seen, a reset is inserted before the ellipsis so no color leaks past it.
+func truncateWidth(s string, maxWidth int) string {
+	if displayWidth(s) <= maxWidth {
+		return s
+	}
+	if maxWidth <= 0 {
+		return ""
+	}
+
+	limit := maxWidth - displayWidth(ellipsis)
+	var b strings.Builder
+	width := 0
+	hasEscapes := false
+
+	for len(s) > 0 {
+		if s[0] == '\x1b' {
+			if loc := ansiPattern.FindStringIndex(s); loc != nil && loc[0] == 0 {
+				b.WriteString(s[:loc[1]])
+				s = s[loc[1]:]
+				hasEscapes = true
+				continue
+			}
+		}
+
+		r, size := utf8.DecodeRuneInString(s)
+		w := runeWidth(r)
+		if width+w > limit {
+			break
+		}
+		b.WriteString(s[:size])
+		width += w
+		s = s[size:]
+	}
+
+	if hasEscapes {
+		b.WriteString(resetCode)
+	}
+	b.WriteString(ellipsis)
+	return b.String()
+}
+
+// resolveMaxWidth returns the width the statusline must fit in: the configured
+// max_width, else the COLUMNS environment variable, else 0 (no limit)
+func resolveMaxWidth(configured int) int {
+	if configured > 0 {
+		return configured
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestDisplayWidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected int
+	}{
+		{name: "ascii", text: "main", expected: 4},
+		{name: "ansi codes excluded", text: "\033[1;32mmain\033[0m", expected: 4},
+		{name: "hyperlink excluded", text: "\033]8;;https://example.com\033\\link\033]8;;\033\\", expected: 4},
+		{name: "east asian wide", text: "日本語", expected: 6},
+		{name: "fullwidth", text: "ＡＢ", expected: 4},
+		{name: "halfwidth katakana", text: "ｶﾅ", expected: 2},
+		{name: "emoji", text: "🔥ok", expected: 4},
+		{name: "combining mark", text: "é", expected: 1},
+		{name: "zero width joiner", text: "a‍b", expected: 2},
+		{name: "powerline glyph", text: "", expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := displayWidth(tt.text); result != tt.expected {
+				t.Errorf("displayWidth(%q) = %d, want %d", tt.text, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTruncateWidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxWidth int
+		expected string
+	}{
+		{name: "fits", text: "main", maxWidth: 4, expected: "main"},
+		{name: "ascii", text: "feature/long-branch", maxWidth: 8, expected: "feature…"},
+		{name: "wide character not split", text: "日本語", maxWidth: 4, expected: "日…"},
+		{name: "escapes kept and reset", text: "\033[31mabcdef\033[0m", maxWidth: 4, expected: "\033[31mabc\033[0m…"},
+		{name: "only ellipsis", text: "abc", maxWidth: 1, expected: "…"},
+		{name: "zero", text: "abc", maxWidth: 0, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := truncateWidth(tt.text, tt.maxWidth)
+			if result != tt.expected {
+				t.Errorf("truncateWidth(%q, %d) = %q, want %q", tt.text, tt.maxWidth, result, tt.expected)
+			}
+			if w := displayWidth(result); w > tt.maxWidth {
+				t.Errorf("truncateWidth(%q, %d) is %d cells wide", tt.text, tt.maxWidth, w)
+			}
+		})
+	}
+}
+
+func TestResolveMaxWidth(t *testing.T) {
+	t.Setenv("COLUMNS", "120")
+	if got := resolveMaxWidth(80); got != 80 {
+		t.Errorf("resolveMaxWidth(80) = %d, want 80", got)
+	}
+	if got := resolveMaxWidth(0); got != 120 {
+		t.Errorf("resolveMaxWidth(0) = %d, want 120", got)
+	}
+
+	t.Setenv("COLUMNS", "")
+	if got := resolveMaxWidth(0); got != 0 {
+		t.Errorf("resolveMaxWidth(0) without COLUMNS = %d, want 0", got)
+	}
+}